@@ -0,0 +1,98 @@
+package endpoints
+
+import (
+	"fmt"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/hero"
+	"restapi.app/api/middlewares"
+	"restapi.app/lib"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service/cron"
+	"restapi.app/service/utils"
+)
+
+// HAlerts endpoint handler struct for the battery alerts
+type HAlerts struct {
+	response *utils.SvcResponse
+	service  *cron.ISvcAlerts
+}
+
+// NewAlertsHandler create and register the handler for the battery alerts
+//
+// - app [*iris.Application] ~ Iris App instance
+//
+// - MdwAuthChecker [*context.Handler] ~ Authentication checker middleware
+//
+// - mdwRoleChecker [middlewares.RoleChecker] ~ Role checker middleware, it requires a role of the access token
+//
+// - mdwScopeChecker [middlewares.ScopeChecker] ~ Scope checker middleware, it requires the scopes granted in the access token
+//
+// - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
+//
+// - svcAlerts [*cron.ISvcAlerts] ~ Alerts service instance
+func NewAlertsHandler(app *iris.Application, mdwAuthChecker *context.Handler, mdwRoleChecker middlewares.RoleChecker, mdwScopeChecker middlewares.ScopeChecker, svcR *utils.SvcResponse, svcAlerts *cron.ISvcAlerts) HAlerts { // --- VARS SETUP ---
+	h := HAlerts{svcR, svcAlerts}
+
+	// Simple group: v1
+	v1 := app.Party("/api/v1")
+	{
+		// registering protected / guarded router
+		guardAlertsRouter := v1.Party("/alerts")
+		{
+			// --- GROUP / PARTY MIDDLEWARES ---
+			guardAlertsRouter.Use(*mdwAuthChecker)
+			guardAlertsRouter.Use(mdwRoleChecker(dto.RoleViewer))
+			guardAlertsRouter.Use(mdwScopeChecker(dto.ScopeDronesRead))
+
+			guardAlertsRouter.Get("/", h.GetAlerts)
+
+			// --- DEPENDENCIES ---
+			hero.Register(DepObtainUserDid)
+		}
+	}
+	return h
+}
+
+// region ======== ENDPOINT HANDLERS =====================================================
+
+// GetAlerts get the battery alerts
+// @Summary Get the battery alerts, the newest first
+// @description.markdown GetAlertsDescription
+// @Tags alerts
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    query   string  false   "alerts of a drone"
+// @Param   status          query   int     false   "alerts with this status"    Enums(0, 1, 2, 3)
+// @Success 200 {object} []dto.Alert "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.query_parameter"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /alerts [get]
+func (h HAlerts) GetAlerts(ctx iris.Context) {
+	filter := dto.AlertFilter{SerialNumber: ctx.URLParamTrim("serialNumber")}
+	if ctx.URLParamExists("status") {
+		qStatus, err := ctx.URLParamInt("status")
+		status := dto.AlertStatus(qStatus)
+		if err != nil || qStatus < 0 || status.String() == "unknown" {
+			h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, fmt.Sprintf("the status must be an integer between %d and %d", dto.AlertRecorded, dto.AlertFailed)), &ctx)
+			return
+		}
+		filter.Status = &status
+	}
+
+	alerts, problem := (*h.service).GetAlertsSvc(&filter)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(alerts, &ctx)
+}
+
+// endregion =============================================================================
@@ -1,6 +1,8 @@
 package endpoints
 
 import (
+	"strings"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
@@ -9,7 +11,6 @@ import (
 	"restapi.app/repo/db"
 	"restapi.app/schema"
 	"restapi.app/schema/dto"
-	"restapi.app/schema/mapper"
 	"restapi.app/service"
 	"restapi.app/service/auth"
 	"restapi.app/service/utils"
@@ -32,14 +33,15 @@ type HAuth struct {
 // - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
 //
 // - svcC [utils.SvcConfig] ~ Configuration service instance
-func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR *utils.SvcResponse, svcC *utils.SvcConfig, validate *validator.Validate) HAuth { // --- VARS SETUP ---
+//
+// - repoDrones [*db.RepoDrones] ~ Drones repository instance, it owns the store database handle
+func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR *utils.SvcResponse, svcC *utils.SvcConfig, repoDrones *db.RepoDrones, validate *validator.Validate) HAuth { // --- VARS SETUP ---
 	h := HAuth{svcR, svcC, make(map[string]bool), validate}
 	// filling providers
 	h.providers["firstapp_provider"] = true
 
-	repoDrones := db.NewRepoDrones(svcC)
-	svcAuth := auth.NewSvcAuthentication(h.providers, &repoDrones) // instantiating authentication Service
-	svcDrones := service.NewSvcDronesReqs(&repoDrones)
+	svcAuth := auth.NewSvcAuthentication(h.providers, svcC, repoDrones) // instantiating authentication Service
+	svcDrones := service.NewSvcDronesReqs(svcC, repoDrones)
 
 	// Simple group: v1
 	v1 := app.Party("/api/v1")
@@ -57,6 +59,7 @@ func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 			// --- REGISTERING ENDPOINTS ---
 			// authRouter.Post("/<provider>")	// provider is the auth provider to be used.
 			authRouter.Post("/", hero.Handler(h.authIntent))
+			authRouter.Post("/refresh", hero.Handler(h.refresh))
 		}
 
 		// registering protected router
@@ -67,7 +70,6 @@ func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 
 			// --- DEPENDENCIES ---
 			hero.Register(DepObtainUserDid)
-			hero.Register(repoDrones)
 
 			// --- REGISTERING ENDPOINTS ---
 			guardAuthRouter.Get("/logout", h.logout)
@@ -86,38 +88,69 @@ func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 // @Accept multipart/form-data
 // @Produce json
 // @Param 	credential 	body 	dto.UserCredIn 	true	"User Login Credential"
-// @Success 200 "OK"
+// @Success 200 {object} dto.TokenResponse "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
 // @Failure 400 {object} dto.Problem "err.wrong_auth_provider"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Failure 500 {object} dto.Problem "err.json_parse"
 // @Router /auth [post]
-func (h HAuth) authIntent(ctx iris.Context, uCred *dto.UserCredIn, svcAuth *auth.SvcAuthentication, r service.ISvcDrones) {
+func (h HAuth) authIntent(ctx iris.Context, uCred *dto.UserCredIn, svcAuth *auth.SvcAuthentication) {
 	// using a provider named 'drones', also injecting dependencies
 	provider := "firstapp_provider"
 
-	populate := r.IsPopulateDBSvc()
-	if !populate {
-		h.response.ResErr(&dto.Problem{Status: iris.StatusInternalServerError, Title: schema.ErrBuntdbNotPopulated, Detail: "The database has not been populated yet"}, &ctx)
+	authGrantedData, problem := svcAuth.AuthProviders[provider].GrantIntent(uCred, nil) // requesting authorization to evote (provider) mechanisms in this case
+	if problem != nil {                                                                 // check for errors
+		h.response.ResErr(problem, &ctx)
 		return
 	}
 
-	authGrantedData, problem := svcAuth.AuthProviders[provider].GrantIntent(uCred, nil) // requesting authorization to evote (provider) mechanisms in this case
-	if problem != nil {                                                                 // check for errors
+	// the requested scopes are trimmed to the ones allowed to the role of the user
+	authGrantedData.Scope = dto.GrantScopes(authGrantedData.Role, strings.Fields(uCred.Scope))
+	if len(authGrantedData.Scope) == 0 {
+		h.response.ResErr(lib.NewProblem(iris.StatusForbidden, schema.ErrUnauthorized, "none of the requested scopes is allowed to the user"), &ctx)
+		return
+	}
+
+	// if so far so good, we are going to create the auth tokens
+	tokens, problem := svcAuth.IssueTokens(authGrantedData)
+	if problem != nil {
 		h.response.ResErr(problem, &ctx)
 		return
 	}
 
-	// TODO: pass this to the service
-	// if so far so good, we are going to create the auth token
-	tokenData := mapper.ToAccessTokenDataV(authGrantedData)
-	accessToken, err := lib.MkAccessToken(tokenData, []byte(h.appConf.JWTSignKey), h.appConf.TkMaxAge)
-	if err != nil {
-		h.response.ResErr(&dto.Problem{Status: iris.StatusInternalServerError, Title: schema.ErrJwtGen, Detail: err.Error()}, &ctx)
+	h.response.ResOKWithData(tokens, &ctx)
+}
+
+// refresh rotates a refresh token and grants a new access token
+// @Summary Refresh the access token
+// @description.markdown RefreshTokenDescription
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param 	refresh 	body 	dto.RequestRefresh 	true	"Refresh token"
+// @Success 200 {object} dto.TokenResponse "OK"
+// @Failure 400 {object} dto.Problem "err.json_parse"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 417 {object} dto.Problem "err.database_related"
+// @Failure 500 {object} dto.Problem "err.jwt_generation"
+// @Router /auth/refresh [post]
+func (h HAuth) refresh(ctx iris.Context, svcAuth *auth.SvcAuthentication) {
+	request := new(dto.RequestRefresh)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrJsonParse, err.Error()), &ctx)
 		return
 	}
 
-	h.response.ResOKWithData(string(accessToken), &ctx)
+	tokens, problem := svcAuth.RefreshTokens(request.RefreshToken)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+
+	h.response.ResOKWithData(tokens, &ctx)
 }
 
 // logout this endpoint invalidated a previously granted access token
@@ -148,14 +181,15 @@ func (h HAuth) logout(ctx iris.Context) {
 // @Param Authorization header string true "Insert access token" default(Bearer <Add access token here>)
 // @Tags Auth
 // @Produce  json
-// @Success 200 {object} []dto.User "OK"
+// @Success 200 {object} dto.User "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
 // @Failure 500 {object} dto.Problem "err.generic
 // @Router /auth/user [get]
-func (h HAuth) userGet(ctx iris.Context, params dto.InjectedParam, r db.RepoDrones) {
-	user, err := r.GetUser(params.Did, true)
-	if err != nil {
-		h.response.ResErr(lib.NewProblem(iris.StatusInternalServerError, schema.ErrBuntdb, err.Error()), &ctx)
+func (h HAuth) userGet(ctx iris.Context, params dto.InjectedParam, r service.ISvcDrones) {
+	user, problem := r.GetUserSvc(params.Did)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
 		return
 	}
 	h.response.ResOKWithData(user, &ctx)
@@ -173,6 +207,7 @@ func depObtainUserCred(ctx iris.Context) dto.UserCredIn {
 	// Getting data
 	cred.Username = ctx.PostValue("username")
 	cred.Password = ctx.PostValue("password")
+	cred.Scope = ctx.PostValue("scope")
 
 	// TIP: We can do some validation here if we want
 	return cred
@@ -4,24 +4,35 @@ import (
 	"fmt"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
+	"log"
+	"strings"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kataras/iris/v12/hero"
+	"restapi.app/api/middlewares"
 	"restapi.app/lib"
 	"restapi.app/repo/db"
 	"restapi.app/schema"
 	"restapi.app/schema/dto"
 	"restapi.app/service"
+	"restapi.app/service/cron"
 	"restapi.app/service/utils"
 )
 
+const (
+	dronesDefaultLimit = 50   // page size used when the limit query parameter is not given
+	dronesMaxLimit     = 500  // maximum page size
+	telemetryMaxItems  = 1000 // maximum entries of a telemetry request
+)
+
 // FirstModuleHandler  endpoint handler struct for Drones
 type FirstModuleHandler struct {
-	response *utils.SvcResponse
-	service  *service.ISvcDrones
-	validate *validator.Validate // handle validations for structs and individual fields based on tags
-	uTrans   *ut.UniversalTranslator
+	response    *utils.SvcResponse
+	service     *service.ISvcDrones
+	svcEventLog *cron.ISvcEventLog
+	validate    *validator.Validate // handle validations for structs and individual fields based on tags
+	uTrans      *ut.UniversalTranslator
 }
 
 // NewFirstModuleHandler create and register the handler for Drones
@@ -30,31 +41,35 @@ type FirstModuleHandler struct {
 //
 // - MdwAuthChecker [*context.Handler] ~ Authentication checker middleware
 //
+// - mdwRoleChecker [middlewares.RoleChecker] ~ Role checker middleware, it requires a role of the access token
+//
+// - mdwScopeChecker [middlewares.ScopeChecker] ~ Scope checker middleware, it requires the scopes granted in the access token
+//
 // - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
 //
 // - svcC [utils.SvcConfig] ~ Configuration service instance
-func NewFirstModuleHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR *utils.SvcResponse, svcC *utils.SvcConfig, validate *validator.Validate, uT *ut.UniversalTranslator) FirstModuleHandler { // --- VARS SETUP ---
-	repoDrones := db.NewRepoDrones(svcC)
-	svc := service.NewSvcDronesReqs(&repoDrones)
+//
+// - repoDrones [*db.RepoDrones] ~ Drones repository instance, it owns the store database handle
+//
+// - svcEventLog [*cron.ISvcEventLog] ~ EventLog service instance, the reported battery levels are added to the event log
+func NewFirstModuleHandler(app *iris.Application, mdwAuthChecker *context.Handler, mdwRoleChecker middlewares.RoleChecker, mdwScopeChecker middlewares.ScopeChecker, svcR *utils.SvcResponse, svcC *utils.SvcConfig, repoDrones *db.RepoDrones, svcEventLog *cron.ISvcEventLog, validate *validator.Validate, uT *ut.UniversalTranslator) FirstModuleHandler { // --- VARS SETUP ---
+	svc := service.NewSvcDronesReqs(svcC, repoDrones)
 	// registering protected / guarded router
-	h := FirstModuleHandler{svcR, &svc, validate, uT}
+	h := FirstModuleHandler{svcR, &svc, svcEventLog, validate, uT}
 
 	app.Get("/status", h.StatusServer)
 
 	// Simple group: v1
 	v1 := app.Party("/api/v1")
 	{
-		// registering unprotected router
-		authRouter := v1.Party("/database") // unauthorized
-		{
-			authRouter.Post("/populate", h.PopulateDB)
-		}
-
 		// registering protected / guarded router
 		guardTxsDatabase := v1.Party("/database")
 		{
 			// --- GROUP / PARTY MIDDLEWARES ---
 			guardTxsDatabase.Use(*mdwAuthChecker)
+			guardTxsDatabase.Use(mdwScopeChecker(dto.ScopeAdminDatabase))
+
+			guardTxsDatabase.Post("/populate", mdwRoleChecker(dto.RoleAdmin), h.PopulateDB)
 
 			// --- DEPENDENCIES ---
 			hero.Register(DepObtainUserDid)
@@ -65,10 +80,22 @@ func NewFirstModuleHandler(app *iris.Application, mdwAuthChecker *context.Handle
 		{
 			// --- GROUP / PARTY MIDDLEWARES ---
 			guardTxsRouter.Use(*mdwAuthChecker)
+			guardTxsRouter.Use(mdwRoleChecker(dto.RoleViewer))
+			guardTxsRouter.Use(mdwScopeChecker(dto.ScopeDronesRead))
+			dispatcher := mdwRoleChecker(dto.RoleDispatcher)
+			write := mdwScopeChecker(dto.ScopeDronesWrite)
 
 			guardTxsRouter.Get("/", h.GetDrones)
+			guardTxsRouter.Get("/available", h.GetAvailableDrones)
+			guardTxsRouter.Post("/telemetry", dispatcher, write, h.IngestTelemetry)
 			guardTxsRouter.Get("/{serialNumber:string}", h.GetADrone)
-			guardTxsRouter.Post("/", h.RegisterADrone)
+			guardTxsRouter.Post("/", dispatcher, write, h.RegisterADrone)
+			guardTxsRouter.Put("/{serialNumber:string}", dispatcher, write, h.ReplaceADrone)
+			guardTxsRouter.Patch("/{serialNumber:string}", dispatcher, write, h.PatchADrone)
+			guardTxsRouter.Delete("/{serialNumber:string}", dispatcher, write, h.DecommissionADrone)
+			guardTxsRouter.Post("/{serialNumber:string}/transitions", dispatcher, write, h.TransitionADrone)
+			guardTxsRouter.Get("/{serialNumber:string}/battery", h.GetBatteryLevel)
+			guardTxsRouter.Put("/{serialNumber:string}/battery", dispatcher, write, h.UpdateBatteryLevel)
 
 			// --- DEPENDENCIES ---
 			hero.Register(DepObtainUserDid)
@@ -79,10 +106,19 @@ func NewFirstModuleHandler(app *iris.Application, mdwAuthChecker *context.Handle
 		{
 			// --- GROUP / PARTY MIDDLEWARES ---
 			guardMedicationsRouter.Use(*mdwAuthChecker)
+			guardMedicationsRouter.Use(mdwRoleChecker(dto.RoleViewer))
+			guardMedicationsRouter.Use(mdwScopeChecker(dto.ScopeMedicationsRead))
+			dispatcher := mdwRoleChecker(dto.RoleDispatcher)
+			write := mdwScopeChecker(dto.ScopeMedicationsWrite)
 
 			guardMedicationsRouter.Get("/", h.GetMedications)
+			guardMedicationsRouter.Post("/{code:string}", dispatcher, write, h.AddMedication)
+			guardMedicationsRouter.Put("/{code:string}", dispatcher, write, h.UpdateMedication)
+			guardMedicationsRouter.Delete("/{code:string}", dispatcher, write, h.DeleteMedication)
 			guardMedicationsRouter.Get("/items/{serialNumber:string}", h.CheckingLoadedMedicationItems)
-			guardMedicationsRouter.Post("/items/{serialNumber:string}", h.LoadMedicationItems)
+			guardMedicationsRouter.Post("/items/{serialNumber:string}", dispatcher, write, h.LoadMedicationItems)
+			guardMedicationsRouter.Delete("/items/{serialNumber:string}", dispatcher, write, h.UnloadMedicationItems)
+			guardMedicationsRouter.Delete("/items/{serialNumber:string}/{code:string}", dispatcher, write, h.RemoveMedicationItem)
 
 			// --- DEPENDENCIES ---
 			hero.Register(DepObtainUserDid)
@@ -99,10 +135,13 @@ func (h FirstModuleHandler) StatusServer(ctx iris.Context) {
 // @Summary Populate the database with fake data
 // @description.markdown PopulateDbDescription
 // @Tags database
+// @Security ApiKeyAuth
 // @Accept  json
 // @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
 // @Success 204 "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 500 {object} dto.Problem "err.database_related"
 // @Failure 504 {object} dto.Problem "err.network"
@@ -117,32 +156,84 @@ func (h FirstModuleHandler) PopulateDB(ctx iris.Context) {
 }
 
 // GetDrones get drones
-// @Summary Get drones
+// @Summary Get a page of drones, filtered and sorted
 // @description.markdown GetDronesDescription
 // @Tags drones
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce json
 // @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
-// @Param   state           query   int     false   "drone state"         Enums(0, 1, 2, 3, 4, 5)
-// @Success 200 {object} []dto.Drone "OK"
-// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Param   state                   query   int     false   "drone state"         Enums(0, 1, 2, 3, 4, 5)
+// @Param   model                   query   int     false   "drone model"         Enums(0, 1, 2, 3)
+// @Param   minBattery              query   number  false   "minimum battery capacity (inclusive)"    minimum(0) maximum(100)
+// @Param   maxBattery              query   number  false   "maximum battery capacity (inclusive)"    minimum(0) maximum(100)
+// @Param   sort                    query   string  false   "sort field"          Enums(battery, serial, model) default(battery)
+// @Param   order                   query   string  false   "sort order, by default descending for battery and ascending for the rest"   Enums(asc, desc)
+// @Param   limit                   query   int     false   "maximum number of drones returned"       minimum(1) maximum(500) default(50)
+// @Param   cursor                  query   string  false   "nextCursor returned by the previous page"
+// @Param   includeDecommissioned   query   bool    false   "include the decommissioned drones"    default(false)
+// @Success 200 {object} dto.DronesPage "OK"
+// @Failure 400 {object} dto.Problem "err.query_parameter"
 // @Failure 500 {object} dto.Problem "err.database_related"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /drones [get]
 func (h FirstModuleHandler) GetDrones(ctx iris.Context) {
-	qState, err := ctx.URLParamInt("state")
-	if err != nil && err != iris.ErrNotFound {
-		h.response.ResErr(lib.NewProblem(iris.StatusInternalServerError, schema.ErrParamURL, err.Error()), &ctx)
+	filter, err := parseDroneFilter(ctx)
+	if err != nil {
+		h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, err.Error()), &ctx)
+		return
+	}
+
+	drones, problem := (*h.service).GetDronesSvc(filter)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
 		return
 	}
+	h.response.ResOKWithData(drones, &ctx)
+}
+
+// GetAvailableDrones get the drones available for loading
+// @Summary Get the drones that can be loaded with a payload, ranked by remaining capacity and battery
+// @description.markdown GetAvailableDronesDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   weight          query   number  false   "payload weight"      minimum(0)
+// @Param   codes           query   string  false   "comma separated medication codes, a repeated code is one more unit"
+// @Success 200 {object} []dto.AvailableDrone "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.query_parameter"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/available [get]
+func (h FirstModuleHandler) GetAvailableDrones(ctx iris.Context) {
+	var payloadWeight float64
+	if ctx.URLParamExists("weight") {
+		var err error
+		payloadWeight, err = ctx.URLParamFloat64("weight")
+		if err != nil || payloadWeight < 0 {
+			h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "the weight must be a non-negative number"), &ctx)
+			return
+		}
+	}
 
-	var state = ""
-	// if no query parameter is passed then we show all drones
-	if qState != -1 {
-		state = fmt.Sprintf("\"state\":%d", qState)
+	medicationItems := make([]dto.MedicationItem, 0)
+	if codes := ctx.URLParamTrim("codes"); codes != "" {
+		for _, code := range strings.Split(codes, ",") {
+			code = strings.TrimSpace(code)
+			if code == "" || !lib.ValidateString(code, dto.RegexpMedicationCode) {
+				h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "there is at least one medication code with invalid format"), &ctx)
+				return
+			}
+			medicationItems = append(medicationItems, dto.MedicationItem{Code: code, Quantity: 1})
+		}
 	}
-	drones, problem := (*h.service).GetDronesSvc(state)
+
+	drones, problem := (*h.service).GetAvailableDronesSvc(medicationItems, payloadWeight)
 	if problem != nil {
 		h.response.ResErr(problem, &ctx)
 		return
@@ -180,7 +271,7 @@ func (h FirstModuleHandler) GetADrone(ctx iris.Context) {
 }
 
 // RegisterADrone registers a new drone
-// @Summary Registers a new drone, also updates a previously inserted drone
+// @Summary Registers a new drone
 // @description.markdown RegisterADroneDescription
 // @Tags drones
 // @Security ApiKeyAuth
@@ -190,7 +281,9 @@ func (h FirstModuleHandler) GetADrone(ctx iris.Context) {
 // @Param	drone			body	dto.RequestDrone	true	"Drone data"
 // @Success 204 "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
 // @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
 // @Failure 500 {object} dto.Problem "err.database_related"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /drones [post]
@@ -200,11 +293,9 @@ func (h FirstModuleHandler) RegisterADrone(ctx iris.Context) {
 	// unmarshalling the JSON from request's body and validate fields
 	if err := ctx.ReadJSON(drone); err != nil {
 		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
 	}
 
-	// calculate drone weight limit
-	drone.WeightLimit = lib.CalculateDroneWeightLimit(drone.Model)
-
 	problem := (*h.service).RegisterDroneSvc(drone)
 	if problem != nil {
 		h.response.ResErr(problem, &ctx)
@@ -213,6 +304,311 @@ func (h FirstModuleHandler) RegisterADrone(ctx iris.Context) {
 	h.response.ResOK(&ctx)
 }
 
+// ReplaceADrone replaces a drone
+// @Summary Replaces a registered drone
+// @description.markdown ReplaceADroneDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			    true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string              true    "Serial number of a drone"     Format(string)
+// @Param	drone			body	dto.RequestDrone	true	"Drone data"
+// @Success 200 {object} dto.Drone "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_illegal_state_transition"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/{serialNumber} [put]
+func (h FirstModuleHandler) ReplaceADrone(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	request := new(dto.RequestDrone)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+	if request.SerialNumber != serialNumber {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the serial number of the body does not match the path"}, &ctx)
+		return
+	}
+
+	drone, problem := (*h.service).ReplaceDroneSvc(&dto.Drone{SerialNumber: request.SerialNumber, Model: request.Model, BatteryCapacity: request.BatteryCapacity, State: request.State})
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(drone, &ctx)
+}
+
+// PatchADrone updates some fields of a drone
+// @Summary Updates some fields of a registered drone (JSON Merge Patch)
+// @description.markdown PatchADroneDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			    true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string              true    "Serial number of a drone"     Format(string)
+// @Param	patch			body	dto.RequestDrone	true	"Fields to update"
+// @Success 200 {object} dto.Drone "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.validation_field"
+// @Failure 412 {object} dto.Problem "err.drone_illegal_state_transition"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/{serialNumber} [patch]
+func (h FirstModuleHandler) PatchADrone(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	patch := make(map[string]interface{})
+	// unmarshalling the JSON Merge Patch from request's body
+	if err := ctx.ReadJSON(&patch); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	// a null removes a member in a JSON Merge Patch, every field of a drone is required
+	for field, value := range patch {
+		if value == nil {
+			h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: fmt.Sprintf("the field %s can not be removed", field)}, &ctx)
+			return
+		}
+	}
+
+	drone, problem := (*h.service).PatchDroneSvc(serialNumber, patch, func(drone *dto.RequestDrone) error {
+		return h.validate.Struct(drone)
+	})
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(drone, &ctx)
+}
+
+// DecommissionADrone decommissions a drone
+// @Summary Removes a drone from the fleet, a tombstone with the decommission date is kept
+// @description.markdown DecommissionADroneDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string  true    "Serial number of a drone"     Format(string)
+// @Success 204 "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_busy"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/{serialNumber} [delete]
+func (h FirstModuleHandler) DecommissionADrone(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+	isValid := lib.ValidateSerialNumberDrone(h.validate, serialNumber)
+	if !isValid {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the serial number of a drone must have a 100 characters max"}, &ctx)
+		return
+	}
+
+	problem := (*h.service).DecommissionDroneSvc(serialNumber)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResDelete(&ctx)
+}
+
+// TransitionADrone moves a drone to a new state
+// @Summary Moves a drone to a new state following the drone state machine
+// @description.markdown TransitionADroneDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			        true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string                  true    "Serial number of a drone"     Format(string)
+// @Param	transition		body	dto.RequestTransition	true	"Target state"
+// @Success 200 {object} dto.Drone "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_illegal_state_transition"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/{serialNumber}/transitions [post]
+func (h FirstModuleHandler) TransitionADrone(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	transition := new(dto.RequestTransition)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(transition); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+
+	drone, problem := (*h.service).TransitionDroneSvc(serialNumber, transition.State)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(drone, &ctx)
+}
+
+// IngestTelemetry applies the battery level and state reported for many drones
+// @Summary Applies the battery level and state reported by a ground station for many drones
+// @description.markdown IngestTelemetryDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			            true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param	telemetry		body	[]dto.RequestTelemetry 	    true	"Battery level and state of the drones"
+// @Success 200 {object} []dto.TelemetryResult "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/telemetry [post]
+func (h FirstModuleHandler) IngestTelemetry(ctx iris.Context) {
+	var telemetry []dto.RequestTelemetry
+	// unmarshalling the JSON from request's body, each entry is validated on its own
+	if err := ctx.ReadJSON(&telemetry); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if len(telemetry) == 0 || len(telemetry) > telemetryMaxItems {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: fmt.Sprintf("the telemetry must have between 1 and %d entries", telemetryMaxItems)}, &ctx)
+		return
+	}
+
+	results, problem := (*h.service).ApplyTelemetrySvc(telemetry, func(entry *dto.RequestTelemetry) error {
+		return h.validate.Struct(entry)
+	})
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	// the applied battery levels are added to the history/audit event log. The telemetry is already saved, so a
+	// failure is only logged and the results of the entries are still returned
+	levels := make([]dto.DroneBatteryLevel, 0, len(*results))
+	for i, result := range *results {
+		if result.Problem == nil {
+			levels = append(levels, dto.DroneBatteryLevel{SerialNumber: result.SerialNumber, BatteryCapacity: *telemetry[i].BatteryCapacity})
+		}
+	}
+	if len(levels) > 0 {
+		if problem = (*h.svcEventLog).AddBatteryLevelsSvc(levels, dto.LogEventSourceTelemetry); problem != nil {
+			log.Printf("error adding the battery levels of %d drones to the event log: %s", len(levels), problem.Detail)
+		}
+	}
+	h.response.ResOKWithData(results, &ctx)
+}
+
+// GetBatteryLevel get the battery level of a drone
+// @Summary Get the battery level of a drone
+// @description.markdown GetBatteryLevelDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string  true    "Serial number of a drone"     Format(string)
+// @Success 200 {object} dto.DroneBatteryLevel "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/{serialNumber}/battery [get]
+func (h FirstModuleHandler) GetBatteryLevel(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	batteryLevel, problem := (*h.service).GetBatteryLevelSvc(serialNumber)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(batteryLevel, &ctx)
+}
+
+// UpdateBatteryLevel updates the battery level of a drone
+// @Summary Updates the battery level reported by a drone
+// @description.markdown UpdateBatteryLevelDescription
+// @Tags drones
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			            true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string                      true    "Serial number of a drone"     Format(string)
+// @Param	batteryLevel	body	dto.RequestBatteryLevel 	true	"Battery level (0 - 100)"
+// @Success 200 {object} dto.DroneBatteryLevel "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /drones/{serialNumber}/battery [put]
+func (h FirstModuleHandler) UpdateBatteryLevel(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	request := new(dto.RequestBatteryLevel)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+
+	batteryLevel, problem := (*h.service).UpdateBatteryLevelSvc(serialNumber, *request.BatteryCapacity)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	// the reported battery level is added to the history/audit event log. The battery level is already saved, so
+	// a failure is only logged: an error would make the client retry an update that succeeded
+	problem = (*h.svcEventLog).AddBatteryLevelsSvc([]dto.DroneBatteryLevel{*batteryLevel}, dto.LogEventSourceDrone)
+	if problem != nil {
+		log.Printf("error adding the battery level of the drone %s to the event log: %s", serialNumber, problem.Detail)
+	}
+	h.response.ResOKWithData(batteryLevel, &ctx)
+}
+
 // endregion =============================================================================
 
 // region ======== Medications ======================================================
@@ -239,6 +635,103 @@ func (h FirstModuleHandler) GetMedications(ctx iris.Context) {
 	h.response.ResOKWithData(medications, &ctx)
 }
 
+// AddMedication adds a new medication
+// @Summary Adds a new medication to the catalogue
+// @description.markdown AddMedicationDescription
+// @Tags medications
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   code            path    string          true    "Medication code"     Format(string)
+// @Param	medication		body	dto.Medication	true	"Medication data"
+// @Success 201 "Created"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /medications/{code} [post]
+func (h FirstModuleHandler) AddMedication(ctx iris.Context) {
+	medication, ok := h.readMedication(ctx)
+	if !ok {
+		return
+	}
+
+	problem := (*h.service).AddMedicationSvc(medication)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResCreated(&ctx)
+}
+
+// UpdateMedication updates a medication
+// @Summary Updates a medication of the catalogue
+// @description.markdown UpdateMedicationDescription
+// @Tags medications
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   code            path    string          true    "Medication code"     Format(string)
+// @Param	medication		body	dto.Medication	true	"Medication data"
+// @Success 204 "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /medications/{code} [put]
+func (h FirstModuleHandler) UpdateMedication(ctx iris.Context) {
+	medication, ok := h.readMedication(ctx)
+	if !ok {
+		return
+	}
+
+	problem := (*h.service).UpdateMedicationSvc(medication)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// DeleteMedication deletes a medication
+// @Summary Deletes a medication of the catalogue, a medication loaded on a drone can not be deleted
+// @description.markdown DeleteMedicationDescription
+// @Tags medications
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   code            path    string  true    "Medication code"     Format(string)
+// @Success 204 "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.medication_loaded"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /medications/{code} [delete]
+func (h FirstModuleHandler) DeleteMedication(ctx iris.Context) {
+	// checking the code param
+	code := ctx.Params().GetString("code")
+	if code == "" || !lib.ValidateString(code, dto.RegexpMedicationCode) {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the medication code has an invalid format"}, &ctx)
+		return
+	}
+
+	problem := (*h.service).DeleteMedicationSvc(code)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResDelete(&ctx)
+}
+
 // CheckingLoadedMedicationItems checking loaded medication items for a given drone
 // @Summary Checking loaded medication items for a given drone
 // @description.markdown CheckingLoadedMedicationsItemsDescription
@@ -248,7 +741,7 @@ func (h FirstModuleHandler) GetMedications(ctx iris.Context) {
 // @Produce json
 // @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
 // @Param   serialNumber    path    string  true    "Serial number of a drone"     Format(string)
-// @Success 200 {object} []string "OK"
+// @Success 200 {object} dto.LoadedMedications "OK"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 500 {object} dto.Problem "err.database_related"
 // @Failure 504 {object} dto.Problem "err.network"
@@ -266,12 +759,12 @@ func (h FirstModuleHandler) CheckingLoadedMedicationItems(ctx iris.Context) {
 		return
 	}
 
-	medicationsIDs, problem := (*h.service).CheckingLoadedMedicationsItemsSvc(serialNumber)
+	loadedMedications, problem := (*h.service).CheckingLoadedMedicationsItemsSvc(serialNumber)
 	if problem != nil {
 		h.response.ResErr(problem, &ctx)
 		return
 	}
-	h.response.ResOKWithData(medicationsIDs, &ctx)
+	h.response.ResOKWithData(loadedMedications, &ctx)
 }
 
 // LoadMedicationItems load a drone with medication items
@@ -283,9 +776,11 @@ func (h FirstModuleHandler) CheckingLoadedMedicationItems(ctx iris.Context) {
 // @Produce json
 // @Param	Authorization	     header	    string 			true 	"Insert access token" default(Bearer <Add access token here>)
 // @Param   serialNumber         path       string          true    "Serial number of a drone"                                     Format(string)
-// @Param	medicationItemCodes  body	    []string		true	"Medication item codes' collection"
+// @Param   append               query      bool            false   "add the items to the already loaded ones"                     default(false)
+// @Param	medicationItems      body	    []dto.MedicationItem	true	"Medication items' collection (code and quantity)"
 // @Success 204 "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 500 {object} dto.Problem "err.database_related"
 // @Failure 504 {object} dto.Problem "err.network"
@@ -303,26 +798,124 @@ func (h FirstModuleHandler) LoadMedicationItems(ctx iris.Context) {
 		return
 	}
 
-	medicationItemIDs := make([]interface{}, 0)
+	medicationItems := make([]dto.MedicationItem, 0)
 	// unmarshalling the JSON from request's body and check
-	if err := ctx.ReadJSON(&medicationItemIDs); err != nil {
+	if err := ctx.ReadJSON(&medicationItems); err != nil {
 		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
+	if len(medicationItems) == 0 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "at least one medication item is required"}, &ctx)
+		return
+	}
+
+	// if there is an error, then there is at least one medication item with invalid code or quantity
+	for _, item := range medicationItems {
+		if err := h.validate.Struct(item); err != nil {
+			h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "there is at least one medication item with invalid code format or quantity"}, &ctx)
+			return
+		}
+	}
+
+	appendItems := false
+	if ctx.URLParamExists("append") {
+		var err error
+		if appendItems, err = ctx.URLParamBool("append"); err != nil {
+			h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "the append parameter must be a boolean"), &ctx)
+			return
+		}
+	}
+
+	problem := (*h.service).LoadMedicationItemsADroneSvc(serialNumber, medicationItems, appendItems)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
 
-	// if false, then there is at least one invalid medication item id
-	isValid = lib.ValidateStringCollection(medicationItemIDs, dto.RegexpMedicationCode)
+// RemoveMedicationItem remove a medication item from a loaded drone
+// @Summary Remove a medication item from a loaded drone
+// @description.markdown RemoveMedicationItemDescription
+// @Tags medications
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string  true    "Serial number of a drone"     Format(string)
+// @Param   code            path    string  true    "Medication item code"         Format(string)
+// @Success 204 "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_not_loaded"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /medications/items/{serialNumber}/{code} [delete]
+func (h FirstModuleHandler) RemoveMedicationItem(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+	isValid := lib.ValidateSerialNumberDrone(h.validate, serialNumber)
 	if !isValid {
-		h.response.ResErr(&dto.Problem{Status: iris.StatusInternalServerError, Title: schema.ErrValidationField, Detail: "there is at least one medication item ID with invalid format"}, &ctx)
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the serial number of a drone must have a 100 characters max"}, &ctx)
 		return
 	}
 
-	problem := (*h.service).LoadMedicationItemsADroneSvc(serialNumber, medicationItemIDs)
+	// checking the code param
+	code := ctx.Params().GetString("code")
+	if code == "" || !lib.ValidateString(code, dto.RegexpMedicationCode) {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the medication item code has an invalid format"}, &ctx)
+		return
+	}
+
+	problem := (*h.service).RemoveMedicationItemADroneSvc(serialNumber, code)
 	if problem != nil {
 		h.response.ResErr(problem, &ctx)
 		return
 	}
-	h.response.ResOK(&ctx)
+	h.response.ResDelete(&ctx)
+}
+
+// UnloadMedicationItems unload all the medication items of a drone
+// @Summary Unload all the medication items of a drone
+// @description.markdown UnloadMedicationItemsDescription
+// @Tags medications
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    path    string  true    "Serial number of a drone"     Format(string)
+// @Success 204 "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_not_loaded"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /medications/items/{serialNumber} [delete]
+func (h FirstModuleHandler) UnloadMedicationItems(ctx iris.Context) {
+	// checking the serialNumber param
+	serialNumber := ctx.Params().GetString("serialNumber")
+	if serialNumber == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+	isValid := lib.ValidateSerialNumberDrone(h.validate, serialNumber)
+	if !isValid {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the serial number of a drone must have a 100 characters max"}, &ctx)
+		return
+	}
+
+	problem := (*h.service).UnloadMedicationItemsADroneSvc(serialNumber)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResDelete(&ctx)
 }
 
 // endregion ======== Medications ======================================================
@@ -338,3 +931,102 @@ func DepObtainUserDid(ctx iris.Context) dto.InjectedParam {
 }
 
 // endregion =============================================================================
+
+// region ======== PRIVATE AUX ===========================================================
+
+// readMedication reads and validates the medication of the request body, the code is taken from the path.
+// If the request is not valid the error response is written and false is returned
+func (h FirstModuleHandler) readMedication(ctx iris.Context) (*dto.Medication, bool) {
+	// checking the code param
+	code := ctx.Params().GetString("code")
+	if code == "" || !lib.ValidateString(code, dto.RegexpMedicationCode) {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the medication code has an invalid format"}, &ctx)
+		return nil, false
+	}
+
+	medication := new(dto.Medication)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(medication); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return nil, false
+	}
+	if medication.Code != "" && medication.Code != code {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: "the medication code of the body does not match the path"}, &ctx)
+		return nil, false
+	}
+	medication.Code = code
+	return medication, true
+}
+
+// parseDroneFilter reads the filters, sort and pagination of the drones list from the query parameters
+func parseDroneFilter(ctx iris.Context) (*dto.DroneFilter, error) {
+	filter := dto.DroneFilter{Sort: dto.DroneSortBattery, Limit: dronesDefaultLimit, Cursor: ctx.URLParamTrim("cursor")}
+
+	if ctx.URLParamExists("state") {
+		qState, err := ctx.URLParamInt("state")
+		state := dto.DroneState(qState)
+		if err != nil || qState < 0 || state.String() == "unknown" {
+			return nil, fmt.Errorf("the state must be an integer between %d and %d", dto.IDLE, dto.RETURNING)
+		}
+		filter.State = &state
+	}
+	if ctx.URLParamExists("model") {
+		qModel, err := ctx.URLParamInt("model")
+		model := dto.DroneModel(qModel)
+		if err != nil || qModel < 0 || model.String() == "unknown" {
+			return nil, fmt.Errorf("the model must be an integer between %d and %d", dto.Lightweight, dto.Heavyweight)
+		}
+		filter.Model = &model
+	}
+	for name, bound := range map[string]**float64{"minBattery": &filter.MinBattery, "maxBattery": &filter.MaxBattery} {
+		if ctx.URLParamExists(name) {
+			value, err := ctx.URLParamFloat64(name)
+			if err != nil || value < 0 || value > 100 {
+				return nil, fmt.Errorf("the %s must be a number between 0 and 100", name)
+			}
+			*bound = &value
+		}
+	}
+	if filter.MinBattery != nil && filter.MaxBattery != nil && *filter.MinBattery > *filter.MaxBattery {
+		return nil, fmt.Errorf("the minBattery must be less than or equal to the maxBattery")
+	}
+
+	if ctx.URLParamExists("sort") {
+		filter.Sort = ctx.URLParamTrim("sort")
+		if filter.Sort != dto.DroneSortBattery && filter.Sort != dto.DroneSortSerial && filter.Sort != dto.DroneSortModel {
+			return nil, fmt.Errorf("the sort must be one of %s, %s or %s", dto.DroneSortBattery, dto.DroneSortSerial, dto.DroneSortModel)
+		}
+	}
+	// the drones are sorted descending by battery capacity by default
+	filter.Desc = filter.Sort == dto.DroneSortBattery
+	if ctx.URLParamExists("order") {
+		switch ctx.URLParamTrim("order") {
+		case "asc":
+			filter.Desc = false
+		case "desc":
+			filter.Desc = true
+		default:
+			return nil, fmt.Errorf("the order must be asc or desc")
+		}
+	}
+
+	if ctx.URLParamExists("limit") {
+		limit, err := ctx.URLParamInt("limit")
+		if err != nil || limit < 1 || limit > dronesMaxLimit {
+			return nil, fmt.Errorf("the limit must be an integer between 1 and %d", dronesMaxLimit)
+		}
+		filter.Limit = limit
+	}
+
+	if ctx.URLParamExists("includeDecommissioned") {
+		includeDecommissioned, err := ctx.URLParamBool("includeDecommissioned")
+		if err != nil {
+			return nil, fmt.Errorf("the includeDecommissioned parameter must be a boolean")
+		}
+		filter.IncludeDecommissioned = includeDecommissioned
+	}
+
+	return &filter, nil
+}
+
+// endregion =============================================================================
@@ -0,0 +1,147 @@
+package endpoints
+
+import (
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/hero"
+	"restapi.app/api/middlewares"
+	"restapi.app/lib"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service/cron"
+	"restapi.app/service/utils"
+)
+
+const (
+	eventLogDefaultLimit = 50  // page size used when the limit query parameter is not given
+	eventLogMaxLimit     = 500 // maximum page size
+)
+
+// HEventLog endpoint handler struct for the history/audit event log
+type HEventLog struct {
+	response *utils.SvcResponse
+	service  *cron.ISvcEventLog
+}
+
+// NewEventLogHandler create and register the handler for the history/audit event log
+//
+// - app [*iris.Application] ~ Iris App instance
+//
+// - MdwAuthChecker [*context.Handler] ~ Authentication checker middleware
+//
+// - mdwRoleChecker [middlewares.RoleChecker] ~ Role checker middleware, it requires a role of the access token
+//
+// - mdwScopeChecker [middlewares.ScopeChecker] ~ Scope checker middleware, it requires the scopes granted in the access token
+//
+// - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
+//
+// - svcEventLog [*cron.ISvcEventLog] ~ EventLog service instance
+func NewEventLogHandler(app *iris.Application, mdwAuthChecker *context.Handler, mdwRoleChecker middlewares.RoleChecker, mdwScopeChecker middlewares.ScopeChecker, svcR *utils.SvcResponse, svcEventLog *cron.ISvcEventLog) HEventLog { // --- VARS SETUP ---
+	h := HEventLog{svcR, svcEventLog}
+
+	// Simple group: v1
+	v1 := app.Party("/api/v1")
+	{
+		// registering protected / guarded router
+		guardEventLogRouter := v1.Party("/eventlog")
+		{
+			// --- GROUP / PARTY MIDDLEWARES ---
+			guardEventLogRouter.Use(*mdwAuthChecker)
+			guardEventLogRouter.Use(mdwRoleChecker(dto.RoleViewer))
+			guardEventLogRouter.Use(mdwScopeChecker(dto.ScopeDronesRead))
+
+			guardEventLogRouter.Get("/", h.GetEventLog)
+
+			// --- DEPENDENCIES ---
+			hero.Register(DepObtainUserDid)
+		}
+	}
+	return h
+}
+
+// region ======== ENDPOINT HANDLERS =====================================================
+
+// GetEventLog get the history/audit event log
+// @Summary Get the history/audit event log of the drones battery levels
+// @description.markdown GetEventLogDescription
+// @Tags eventlog
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   from            query   string  false   "events created from this date (inclusive)"    Format(20060102-150405)
+// @Param   to              query   string  false   "events created until this date (inclusive)"   Format(20060102-150405)
+// @Param   serialNumber    query   string  false   "narrows the battery levels to a drone"
+// @Param   offset          query   int     false   "number of events to skip"                     minimum(0)
+// @Param   limit           query   int     false   "maximum number of events returned"            minimum(1) maximum(500) default(50)
+// @Success 200 {object} []dto.LogEvent "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.query_parameter"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /eventlog [get]
+func (h HEventLog) GetEventLog(ctx iris.Context) {
+	filter := dto.LogEventFilter{SerialNumber: ctx.URLParamTrim("serialNumber")}
+
+	var err error
+	if filter.From, err = parseLogEventDate(ctx.URLParamTrim("from")); err != nil {
+		h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "invalid 'from' date: "+err.Error()), &ctx)
+		return
+	}
+	if filter.To, err = parseLogEventDate(ctx.URLParamTrim("to")); err != nil {
+		h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "invalid 'to' date: "+err.Error()), &ctx)
+		return
+	}
+	if filter.From != "" && filter.To != "" && filter.From > filter.To {
+		h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "the 'from' date must be before the 'to' date"), &ctx)
+		return
+	}
+
+	filter.Offset, err = ctx.URLParamInt("offset")
+	if err == iris.ErrNotFound {
+		filter.Offset = 0
+	} else if err != nil || filter.Offset < 0 {
+		h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "the offset must be a non-negative integer"), &ctx)
+		return
+	}
+
+	filter.Limit, err = ctx.URLParamInt("limit")
+	if err == iris.ErrNotFound {
+		filter.Limit = eventLogDefaultLimit
+	} else if err != nil || filter.Limit < 1 || filter.Limit > eventLogMaxLimit {
+		h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "the limit must be an integer between 1 and 500"), &ctx)
+		return
+	}
+
+	logEvents, problem := (*h.service).GetLogEventsSvc(&filter)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(logEvents, &ctx)
+}
+
+// endregion =============================================================================
+
+// region ======== PRIVATE AUX ===========================================================
+
+// parseLogEventDate accepts a date in the event log layout (20060102-150405) or in RFC 3339,
+// and returns it in the event log layout. An empty date is returned as is
+func parseLogEventDate(date string) (string, error) {
+	if date == "" {
+		return "", nil
+	}
+	if t, err := time.Parse(dto.LogEventDateLayout, date); err == nil {
+		return t.Format(dto.LogEventDateLayout), nil
+	}
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(dto.LogEventDateLayout), nil
+}
+
+// endregion =============================================================================
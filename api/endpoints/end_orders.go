@@ -0,0 +1,301 @@
+package endpoints
+
+import (
+	"fmt"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/hero"
+	"restapi.app/api/middlewares"
+	"restapi.app/lib"
+	"restapi.app/repo/db"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service"
+	"restapi.app/service/utils"
+)
+
+// HOrders endpoint handler struct for the delivery orders
+type HOrders struct {
+	response *utils.SvcResponse
+	service  *service.ISvcOrders
+	uTrans   *ut.UniversalTranslator
+}
+
+// NewOrdersHandler create and register the handler for the delivery orders
+//
+// - app [*iris.Application] ~ Iris App instance
+//
+// - MdwAuthChecker [*context.Handler] ~ Authentication checker middleware
+//
+// - mdwRoleChecker [middlewares.RoleChecker] ~ Role checker middleware, it requires a role of the access token
+//
+// - mdwScopeChecker [middlewares.ScopeChecker] ~ Scope checker middleware, it requires the scopes granted in the access token
+//
+// - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
+//
+// - svcC [*utils.SvcConfig] ~ Configuration service instance
+//
+// - repoDrones [*db.RepoDrones] ~ Drones repository instance, the orders are kept with the drones they load
+//
+// - uT [*ut.UniversalTranslator] ~ translations of the validation errors
+func NewOrdersHandler(app *iris.Application, mdwAuthChecker *context.Handler, mdwRoleChecker middlewares.RoleChecker, mdwScopeChecker middlewares.ScopeChecker, svcR *utils.SvcResponse, svcC *utils.SvcConfig, repoDrones *db.RepoDrones, uT *ut.UniversalTranslator) HOrders { // --- VARS SETUP ---
+	svc := service.NewSvcOrdersReqs(svcC, repoDrones)
+	h := HOrders{svcR, &svc, uT}
+
+	// Simple group: v1
+	v1 := app.Party("/api/v1")
+	{
+		// registering protected / guarded router
+		guardOrdersRouter := v1.Party("/orders")
+		{
+			// --- GROUP / PARTY MIDDLEWARES ---
+			guardOrdersRouter.Use(*mdwAuthChecker)
+			guardOrdersRouter.Use(mdwRoleChecker(dto.RoleViewer))
+			guardOrdersRouter.Use(mdwScopeChecker(dto.ScopeOrdersRead))
+			dispatcher := mdwRoleChecker(dto.RoleDispatcher)
+			write := mdwScopeChecker(dto.ScopeOrdersWrite)
+
+			guardOrdersRouter.Get("/", h.GetOrders)
+			guardOrdersRouter.Post("/", dispatcher, write, h.CreateOrder)
+			guardOrdersRouter.Get("/{id:string}", h.GetOrder)
+			guardOrdersRouter.Delete("/{id:string}", dispatcher, write, h.CancelOrder)
+
+			// --- DEPENDENCIES ---
+			hero.Register(DepObtainUserDid)
+		}
+
+		guardDispatchRouter := v1.Party("/dispatch")
+		{
+			// --- GROUP / PARTY MIDDLEWARES ---
+			guardDispatchRouter.Use(*mdwAuthChecker)
+			guardDispatchRouter.Use(mdwRoleChecker(dto.RoleDispatcher))
+			guardDispatchRouter.Use(mdwScopeChecker(dto.ScopeOrdersWrite))
+
+			guardDispatchRouter.Post("/", h.Dispatch)
+			guardDispatchRouter.Post("/plan", h.PlanDispatch)
+		}
+	}
+	return h
+}
+
+// region ======== ENDPOINT HANDLERS =====================================================
+
+// GetOrders get the delivery orders
+// @Summary Get the delivery orders, the newest first
+// @description.markdown GetOrdersDescription
+// @Tags orders
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   serialNumber    query   string  false   "orders of a drone"
+// @Param   status          query   int     false   "orders with this status"    Enums(0, 1, 2, 3)
+// @Success 200 {object} []dto.Order "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.query_parameter"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /orders [get]
+func (h HOrders) GetOrders(ctx iris.Context) {
+	filter := dto.OrderFilter{SerialNumber: ctx.URLParamTrim("serialNumber")}
+	if ctx.URLParamExists("status") {
+		qStatus, err := ctx.URLParamInt("status")
+		status := dto.OrderStatus(qStatus)
+		if err != nil || qStatus < 0 || status.String() == "unknown" {
+			h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, fmt.Sprintf("the status must be an integer between %d and %d", dto.OrderLoaded, dto.OrderCancelled)), &ctx)
+			return
+		}
+		filter.Status = &status
+	}
+
+	orders, problem := (*h.service).GetOrdersSvc(&filter)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(orders, &ctx)
+}
+
+// GetOrder get a delivery order by id
+// @Summary Get a delivery order
+// @description.markdown GetOrderDescription
+// @Tags orders
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   id              path    string  true    "Order id"     Format(string)
+// @Success 200 {object} dto.Order "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /orders/{id} [get]
+func (h HOrders) GetOrder(ctx iris.Context) {
+	// checking the id param
+	id := ctx.Params().GetString("id")
+	if id == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	order, problem := (*h.service).GetOrderSvc(id)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(order, &ctx)
+}
+
+// CreateOrder creates a delivery order
+// @Summary Creates a delivery order and loads the drone with its medication items
+// @description.markdown CreateOrderDescription
+// @Tags orders
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			    true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param	order			body	dto.RequestOrder 	true	"Drone, destination and medication items"
+// @Success 201 {object} dto.Order "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_busy"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /orders [post]
+func (h HOrders) CreateOrder(ctx iris.Context) {
+	request := new(dto.RequestOrder)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+
+	order, problem := (*h.service).CreateOrderSvc(request)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResCreatedWithData(order, &ctx)
+}
+
+// CancelOrder cancels a delivery order
+// @Summary Cancels a delivery order that is not delivering yet
+// @description.markdown CancelOrderDescription
+// @Tags orders
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   id              path    string  true    "Order id"     Format(string)
+// @Success 204 "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.order_closed"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /orders/{id} [delete]
+func (h HOrders) CancelOrder(ctx iris.Context) {
+	// checking the id param
+	id := ctx.Params().GetString("id")
+	if id == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	if problem := (*h.service).CancelOrderSvc(id); problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResDelete(&ctx)
+}
+
+// Dispatch picks a drone and loads it with the medication items
+// @Summary Picks the best IDLE drone for the medication items and loads it through a new order
+// @description.markdown DispatchDescription
+// @Tags orders
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			        true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param	dispatch		body	dto.RequestDispatch 	true	"Medication items, destination and strategy"
+// @Success 201 {object} dto.Dispatch "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_unavailable"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /dispatch [post]
+func (h HOrders) Dispatch(ctx iris.Context) {
+	request := new(dto.RequestDispatch)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+
+	dispatch, problem := (*h.service).DispatchSvc(request)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResCreatedWithData(dispatch, &ctx)
+}
+
+// PlanDispatch splits the medication items across several drones
+// @Summary Plans the dispatch of medication items across several drones, optionally loading them
+// @description.markdown PlanDispatchDescription
+// @Tags orders
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			            true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   commit          query   bool                        false   "load the drones of the plan"     default(false)
+// @Param	plan			body	dto.RequestDispatchPlan 	true	"Medication items and destination"
+// @Success 200 {object} dto.DispatchPlan "OK"
+// @Success 201 {object} dto.DispatchPlan "Committed"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.drone_maximum_load_weight_exceeded"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /dispatch/plan [post]
+func (h HOrders) PlanDispatch(ctx iris.Context) {
+	commit := false
+	if ctx.URLParamExists("commit") {
+		var err error
+		if commit, err = ctx.URLParamBool("commit"); err != nil {
+			h.response.ResErr(lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, "the commit parameter must be a boolean"), &ctx)
+			return
+		}
+	}
+
+	request := new(dto.RequestDispatchPlan)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+
+	plan, problem := (*h.service).PlanDispatchSvc(request, commit)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	if commit {
+		h.response.ResCreatedWithData(plan, &ctx)
+		return
+	}
+	h.response.ResOKWithData(plan, &ctx)
+}
+
+// endregion =============================================================================
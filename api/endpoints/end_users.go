@@ -0,0 +1,242 @@
+package endpoints
+
+import (
+	ut "github.com/go-playground/universal-translator"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/hero"
+	"restapi.app/api/middlewares"
+	"restapi.app/lib"
+	"restapi.app/repo/db"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service"
+	"restapi.app/service/utils"
+)
+
+// HUsers endpoint handler struct for the users management
+type HUsers struct {
+	response *utils.SvcResponse
+	service  *service.ISvcDrones
+	uTrans   *ut.UniversalTranslator
+}
+
+// NewUsersHandler create and register the handler for the users management
+//
+// - app [*iris.Application] ~ Iris App instance
+//
+// - MdwAuthChecker [*context.Handler] ~ Authentication checker middleware
+//
+// - mdwRoleChecker [middlewares.RoleChecker] ~ Role checker middleware, it requires a role of the access token
+//
+// - mdwScopeChecker [middlewares.ScopeChecker] ~ Scope checker middleware, it requires the scopes granted in the access token
+//
+// - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
+//
+// - svcC [*utils.SvcConfig] ~ Configuration service instance
+//
+// - repoDrones [*db.RepoDrones] ~ Drones repository instance, the users are kept in the store database
+//
+// - uT [*ut.UniversalTranslator] ~ translations of the validation errors
+func NewUsersHandler(app *iris.Application, mdwAuthChecker *context.Handler, mdwRoleChecker middlewares.RoleChecker, mdwScopeChecker middlewares.ScopeChecker, svcR *utils.SvcResponse, svcC *utils.SvcConfig, repoDrones *db.RepoDrones, uT *ut.UniversalTranslator) HUsers { // --- VARS SETUP ---
+	svc := service.NewSvcDronesReqs(svcC, repoDrones)
+	h := HUsers{svcR, &svc, uT}
+
+	// Simple group: v1
+	v1 := app.Party("/api/v1")
+	{
+		// registering protected / guarded router
+		guardUsersRouter := v1.Party("/users")
+		{
+			// --- GROUP / PARTY MIDDLEWARES ---
+			guardUsersRouter.Use(*mdwAuthChecker)
+			guardUsersRouter.Use(mdwRoleChecker(dto.RoleAdmin))
+			guardUsersRouter.Use(mdwScopeChecker(dto.ScopeAdminUsers))
+
+			guardUsersRouter.Get("/", h.GetUsers)
+			guardUsersRouter.Post("/", h.CreateUser)
+			guardUsersRouter.Get("/{username:string}", h.GetUser)
+			guardUsersRouter.Patch("/{username:string}", h.UpdateUser)
+			guardUsersRouter.Delete("/{username:string}", h.DisableUser)
+
+			// --- DEPENDENCIES ---
+			hero.Register(DepObtainUserDid)
+		}
+	}
+	return h
+}
+
+// region ======== ENDPOINT HANDLERS =====================================================
+
+// GetUsers get the users
+// @Summary Get the users sorted by username
+// @description.markdown GetUsersDescription
+// @Tags users
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Success 200 {object} []dto.User "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /users [get]
+func (h HUsers) GetUsers(ctx iris.Context) {
+	users, problem := (*h.service).GetUsersSvc()
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(users, &ctx)
+}
+
+// GetUser get a user by username
+// @Summary Get a user
+// @description.markdown GetUserDescription
+// @Tags users
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   username        path    string  true    "Username"     Format(string)
+// @Success 200 {object} dto.User "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /users/{username} [get]
+func (h HUsers) GetUser(ctx iris.Context) {
+	username, ok := h.readUsername(ctx)
+	if !ok {
+		return
+	}
+
+	user, problem := (*h.service).GetUserSvc(username)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(user, &ctx)
+}
+
+// CreateUser creates a user
+// @Summary Creates a user
+// @description.markdown CreateUserDescription
+// @Tags users
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			    true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param	user			body	dto.RequestUser 	true	"Username, password and name"
+// @Success 201 {object} dto.User "Created"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /users [post]
+func (h HUsers) CreateUser(ctx iris.Context) {
+	request := new(dto.RequestUser)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+
+	user, problem := (*h.service).CreateUserSvc(request)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResCreatedWithData(user, &ctx)
+}
+
+// UpdateUser updates some fields of a user
+// @Summary Updates the name, the password or the disabled flag of a user
+// @description.markdown UpdateUserDescription
+// @Tags users
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string 			        true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   username        path    string                  true    "Username"     Format(string)
+// @Param	user			body	dto.RequestUserUpdate	true	"Fields to update"
+// @Success 200 {object} dto.User "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /users/{username} [patch]
+func (h HUsers) UpdateUser(ctx iris.Context) {
+	username, ok := h.readUsername(ctx)
+	if !ok {
+		return
+	}
+
+	request := new(dto.RequestUserUpdate)
+	// unmarshalling the JSON from request's body and validate fields
+	if err := ctx.ReadJSON(request); err != nil {
+		lib.HandleError(ctx, h.uTrans, err, iris.StatusBadRequest)
+		return
+	}
+
+	user, problem := (*h.service).UpdateUserSvc(username, request)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(user, &ctx)
+}
+
+// DisableUser disables a user
+// @Summary Disables a user, the record is kept but the user can not authenticate
+// @description.markdown DisableUserDescription
+// @Tags users
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   username        path    string  true    "Username"     Format(string)
+// @Success 204 "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 412 {object} dto.Problem "err.database_related.item_not_found"
+// @Failure 500 {object} dto.Problem "err.database_related"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /users/{username} [delete]
+func (h HUsers) DisableUser(ctx iris.Context) {
+	username, ok := h.readUsername(ctx)
+	if !ok {
+		return
+	}
+
+	problem := (*h.service).DisableUserSvc(username)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResDelete(&ctx)
+}
+
+// endregion =============================================================================
+
+// region ======== PRIVATE AUX ===========================================================
+
+// readUsername reads the username path param. If it is missing the error response is written and false is returned
+func (h HUsers) readUsername(ctx iris.Context) (string, bool) {
+	username := ctx.Params().GetString("username")
+	if username == "" {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return "", false
+	}
+	return username, true
+}
+
+// endregion =============================================================================
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"fmt"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"restapi.app/lib"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+// RoleChecker builds the middleware that requires a role, or a role with more permissions. It must run
+// after the authentication checker, which verifies the access token and stores its claims
+type RoleChecker func(role string) context.Handler
+
+// NewRoleCheckerMiddleware role based access control middleware, the role comes from the access token claims.
+// It can be used for a party, party.Use(mdwRoleChecker(dto.RoleViewer)), or for a single route
+func NewRoleCheckerMiddleware(svcR *utils.SvcResponse) RoleChecker {
+	return func(role string) context.Handler {
+		return func(ctx iris.Context) {
+			tkData, ok := ctx.Values().Get("iris.jwt.claims").(*dto.AccessTokenData)
+			if !ok {
+				svcR.ResErr(lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, "the access token is missing"), &ctx)
+				return
+			}
+			if !dto.HasRole(tkData.Claims.Role, role) {
+				svcR.ResErr(lib.NewProblem(iris.StatusForbidden, schema.ErrUnauthorized, fmt.Sprintf("the %s role is required", role)), &ctx)
+				return
+			}
+			ctx.Next()
+		}
+	}
+}
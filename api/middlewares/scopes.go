@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"fmt"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"restapi.app/lib"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+// ScopeChecker builds the middleware that requires all the given scopes. It must run after the authentication
+// checker, which verifies the access token and stores its claims
+type ScopeChecker func(scopes ...string) context.Handler
+
+// NewScopeCheckerMiddleware scope checker middleware, the scopes are granted in the access token when the user
+// authenticates. It can be used for a party, party.Use(mdwScopeChecker(dto.ScopeDronesRead)), or for a single route
+func NewScopeCheckerMiddleware(svcR *utils.SvcResponse) ScopeChecker {
+	return func(scopes ...string) context.Handler {
+		return func(ctx iris.Context) {
+			tkData, ok := ctx.Values().Get("iris.jwt.claims").(*dto.AccessTokenData)
+			if !ok {
+				svcR.ResErr(lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, "the access token is missing"), &ctx)
+				return
+			}
+			for _, scope := range scopes {
+				if !dto.HasScope(tkData.Scope, scope) {
+					svcR.ResErr(lib.NewProblem(iris.StatusForbidden, schema.ErrUnauthorized, fmt.Sprintf("the %s scope is required", scope)), &ctx)
+					return
+				}
+			}
+			ctx.Next()
+		}
+	}
+}
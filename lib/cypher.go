@@ -3,10 +3,13 @@ package lib
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/kataras/iris/v12/middleware/jwt"
+	"golang.org/x/crypto/argon2"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"hash"
 	"io"
@@ -16,8 +19,8 @@ import (
 	"restapi.app/schema/dto"
 )
 
-// MkAccessToken create a signed JTW token with the specified data. This could be used for authentication purpose by a middleware
-func MkAccessToken(data *dto.AccessTokenData, sigKey []byte, tkAge uint8) ([]byte, error) { // https://github.com/kataras/iris/blob/master/_examples/auth/jwt/middleware/main.go | https://github.com/iris-contrib/examples/blob/master/auth/jwt/basic/main.go
+// MkAccessToken create a signed JTW token with the specified data, it expires after tkAge minutes. This could be used for authentication purpose by a middleware
+func MkAccessToken(data *dto.AccessTokenData, sigKey []byte, tkAge int) ([]byte, error) { // https://github.com/kataras/iris/blob/master/_examples/auth/jwt/middleware/main.go | https://github.com/iris-contrib/examples/blob/master/auth/jwt/basic/main.go
 	tk, err := jwt.Sign(jwt.HS256, sigKey, data, jwt.MaxAge(time.Duration(tkAge)*time.Minute))
 	if err != nil {
 		return nil, err
@@ -53,6 +56,89 @@ func Checksum(algorithm string, data []byte) (checksum string, err error) {
 	return str, nil
 }
 
+// argon2id parameters of the new password hashes, a hash with other parameters is still verified
+// but it is reported to be rehashed
+const (
+	argon2Memory      = 64 * 1024 // KiB
+	argon2Iterations  = 3
+	argon2Parallelism = 2
+	argon2SaltLength  = 16
+	argon2KeyLength   = 32
+)
+
+// refreshTokenLength random bytes of a refresh token
+const refreshTokenLength = 32
+
+// ErrInvalidPasswordHash when a stored password hash has an unknown format
+var ErrInvalidPasswordHash = errors.New("invalid password hash format")
+
+// HashPassword returns the argon2id hash of a password with a random salt, in the versioned PHC string
+// format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash> (base64 without padding)
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Iterations, argon2Memory, argon2Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Iterations, argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword checks a password against a stored hash, the hashes are compared in constant time.
+// The legacy hashes (unsalted SHA-256 in hex) are still accepted, rehash reports that a matching
+// password must be stored again with HashPassword
+func VerifyPassword(password string, encoded string) (match bool, rehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$") {
+		checksum, _ := Checksum(SHA256, []byte(password))
+		return subtle.ConstantTimeCompare([]byte(checksum), []byte(encoded)) == 1, true, nil
+	}
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[1] != "argon2id" {
+		return false, false, ErrInvalidPasswordHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrInvalidPasswordHash
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err = fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	match = subtle.ConstantTimeCompare(key, other) == 1
+	rehash = memory != argon2Memory || iterations != argon2Iterations || parallelism != argon2Parallelism || len(key) != argon2KeyLength
+	return match, rehash, nil
+}
+
+// NewRefreshToken returns a random opaque refresh token and its id. Only the id, the SHA-256 of the token, is
+// stored, so the stored records can not be used as refresh tokens
+func NewRefreshToken() (token string, id string, err error) {
+	secret := make([]byte, refreshTokenLength)
+	if _, err = io.ReadFull(rand.Reader, secret); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(secret)
+	return token, RefreshTokenID(token), nil
+}
+
+// RefreshTokenID the id of a refresh token, the hex encoded SHA-256 of the token
+func RefreshTokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // GenerateUUIDBytes returns a UUID based on RFC 4122 returning the generated bytes
 func GenerateUUIDBytes() []byte {
 	uuid := make([]byte, 16)
@@ -12,11 +12,51 @@ import (
 	"restapi.app/api/middlewares"
 	"restapi.app/docs"
 	"restapi.app/lib"
+	"restapi.app/repo/db"
+	"restapi.app/service"
 	"restapi.app/service/cron"
 	"restapi.app/service/utils"
 )
 
-func newApp() (*iris.Application, *utils.SvcConfig) {
+// appRuntime the cron jobs and the database handles of the App. The jobs are started once the App is built,
+// and everything is closed on shutdown
+type appRuntime struct {
+	repoDrones   db.RepoDrones
+	repoEventLog db.RepoEventLog
+	svcEventLog  cron.ISvcEventLog
+	svcSimulator cron.ISvcSimulator
+	svcAlerts    cron.ISvcAlerts
+}
+
+// startJobs starts the cron jobs, a job that fails to start is logged and the App keeps running
+func (r *appRuntime) startJobs(app *iris.Application) {
+	if err := r.svcEventLog.MeinerCronJob(); err != nil {
+		app.Logger().Error(err.Error())
+	}
+	// the simulator is opt-in, for environments without real drones
+	if err := r.svcSimulator.StartSimulator(); err != nil {
+		app.Logger().Error(err.Error())
+	}
+	// the alerts are delivered only if there are webhooks in configuration
+	if err := r.svcAlerts.StartAlerts(); err != nil {
+		app.Logger().Error(err.Error())
+	}
+}
+
+// close stops the cron jobs before closing the database handles, it returns the first error closing them
+func (r *appRuntime) close() error {
+	r.svcEventLog.StopCronJob()
+	r.svcSimulator.StopSimulator()
+	r.svcAlerts.StopAlerts()
+	errEventLog := r.repoEventLog.Close()
+	if err := r.repoDrones.Close(); err != nil {
+		return err
+	}
+	return errEventLog
+}
+
+// newApp builds the App, its repositories and its services. The cron jobs are not started, see appRuntime
+func newApp() (*iris.Application, *utils.SvcConfig, db.RepoDrones, *appRuntime) {
 	docs.SwaggerInfo.BasePath = "/api/v1"
 
 	// region ======== GLOBALS ===============================================================
@@ -29,6 +69,26 @@ func newApp() (*iris.Application, *utils.SvcConfig) {
 	// Services
 	svcConfig := utils.NewSvcConfig()              // Creating Configuration Service
 	svcResponse := utils.NewSvcResponse(svcConfig) // Creating Response Service
+
+	// a misconfigured dispatch strategy would fail every dispatch without one
+	if err := service.CheckDispatchStrategy(svcConfig.DispatchStrategy); err != nil {
+		panic(err.Error())
+	}
+
+	// Repositories, they own the database handles during the process lifetime
+	repoDrones, err := db.NewRepoDrones(svcConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	repoEventLog, err := db.NewRepoEventLog(svcConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	svcEventLog := cron.NewSvcRepoEventLog(svcConfig, &repoDrones, &repoEventLog) // Creating EventLog Service
+	svcSimulator := cron.NewSvcSimulator(svcConfig, &repoDrones)                  // Creating Simulator Service
+	svcAlerts := cron.NewSvcAlerts(svcConfig, &repoDrones)                        // Creating Alerts Service
+
+	rt := &appRuntime{repoDrones, repoEventLog, svcEventLog, svcSimulator, svcAlerts}
 	// endregion =============================================================================
 
 	// region ======== MIDDLEWARES ===========================================================
@@ -55,7 +115,7 @@ func newApp() (*iris.Application, *utils.SvcConfig) {
 	}
 
 	// activate validator/v10 package and adding new validators
-	err := lib.InitValidator(validate)
+	err = lib.InitValidator(validate)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -66,13 +126,19 @@ func newApp() (*iris.Application, *utils.SvcConfig) {
 
 	// custom middleware
 	mdwAuthChecker := middlewares.NewAuthCheckerMiddleware([]byte(svcConfig.JWTSignKey))
+	mdwRoleChecker := middlewares.NewRoleCheckerMiddleware(svcResponse)
+	mdwScopeChecker := middlewares.NewScopeCheckerMiddleware(svcResponse)
 
 	// endregion =============================================================================
 
 	// region ======== ENDPOINT REGISTRATIONS ================================================
 
-	endpoints.NewAuthHandler(app, &mdwAuthChecker, svcResponse, svcConfig, validate)
-	endpoints.NewFirstModuleHandler(app, &mdwAuthChecker, svcResponse, svcConfig, validate, universalTranslator) // Drones request handlers
+	endpoints.NewAuthHandler(app, &mdwAuthChecker, svcResponse, svcConfig, &repoDrones, validate)
+	endpoints.NewFirstModuleHandler(app, &mdwAuthChecker, mdwRoleChecker, mdwScopeChecker, svcResponse, svcConfig, &repoDrones, &svcEventLog, validate, universalTranslator) // Drones request handlers
+	endpoints.NewEventLogHandler(app, &mdwAuthChecker, mdwRoleChecker, mdwScopeChecker, svcResponse, &svcEventLog)                                                           // EventLog request handlers
+	endpoints.NewOrdersHandler(app, &mdwAuthChecker, mdwRoleChecker, mdwScopeChecker, svcResponse, svcConfig, &repoDrones, universalTranslator)                              // Orders request handlers
+	endpoints.NewAlertsHandler(app, &mdwAuthChecker, mdwRoleChecker, mdwScopeChecker, svcResponse, &svcAlerts)                                                               // Alerts request handlers
+	endpoints.NewUsersHandler(app, &mdwAuthChecker, mdwRoleChecker, mdwScopeChecker, svcResponse, svcConfig, &repoDrones, universalTranslator)                               // Users request handlers
 	// endregion =============================================================================
 
 	// region ======== SWAGGER REGISTRATION ==================================================
@@ -80,7 +146,7 @@ func newApp() (*iris.Application, *utils.SvcConfig) {
 	app.Get("/swagger/{any:path}", swagger.WrapHandler(swaggerFiles.Handler))
 	// endregion =============================================================================
 
-	return app, svcConfig
+	return app, svcConfig, repoDrones, rt
 }
 
 // @title GitHub template restapi
@@ -97,12 +163,15 @@ func newApp() (*iris.Application, *utils.SvcConfig) {
 
 // @BasePath /
 func main() {
-	app, svcConfig := newApp()
+	app, svcConfig, _, rt := newApp()
+	rt.startJobs(app)
 
-	// region ======== Cron Job ==================================================
-	cronJob := cron.NewSvcRepoEventLog(svcConfig)
-	_ = cronJob.MeinerCronJob()
-	// endregion =============================================================================
+	// graceful shutdown: the cron jobs are stopped before closing the database handles
+	iris.RegisterOnInterrupt(func() {
+		if err := rt.close(); err != nil {
+			app.Logger().Error(err.Error())
+		}
+	})
 
 	addr := fmt.Sprintf(":%s", svcConfig.DappPort)
 
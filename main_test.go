@@ -2,29 +2,73 @@ package main
 
 import (
 	"encoding/base64"
-
-	"restapi.app/repo/db"
+	"sync"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/brianvoe/gofakeit/v6"
 	"restapi.app/lib"
+	"restapi.app/repo/db"
 	"restapi.app/schema"
 	"restapi.app/schema/dto"
 
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/kataras/iris/v12/httptest"
 )
 
+// newTestServer builds the App on the test configuration with a populated database, everything is closed when the
+// test ends. It returns the Authorization header of the bootstrap admin
+func newTestServer(t *testing.T) (*httptest.Expect, db.RepoDrones, *appRuntime, string) {
+	t.Helper()
+	t.Setenv(schema.EnvConfigPath, "./conf/conf.yaml")
+	app, _, repo, rt := newApp()
+	t.Cleanup(func() { _ = rt.close() })
+	e := httptest.New(t, app)
+
+	if !repo.IsPopulated() {
+		if err := repo.PopulateDB(); err != nil {
+			t.Fatalf("error populating the database")
+		}
+	}
+
+	accessToken := e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: "richard.sargon@meinermail.com", Password: "password1"}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("accessToken").String().Raw()
+	return e, repo, rt, "Bearer " + accessToken
+}
+
+// registerDrone registers a new IDLE drone of the model with a full battery
+func registerDrone(t *testing.T, e *httptest.Expect, auth string, model dto.DroneModel) dto.RequestDrone {
+	t.Helper()
+	drone := dto.RequestDrone{SerialNumber: lib.GenerateUUIDStr(), Model: model, BatteryCapacity: 100, State: dto.IDLE}
+	e.POST("/api/v1/drones").WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusNoContent)
+	return drone
+}
+
+// lightestMedication returns the lightest medication of the populated database
+func lightestMedication(t *testing.T, repo db.RepoDrones) dto.Medication {
+	t.Helper()
+	medications, err := repo.GetMedications()
+	if err != nil || len(*medications) == 0 {
+		t.Fatalf("error getting the medications: %v", err)
+	}
+	lightest := (*medications)[0]
+	for _, medication := range *medications {
+		if medication.Weight < lightest.Weight {
+			lightest = medication
+		}
+	}
+	return lightest
+}
+
 func TestNewApp(t *testing.T) {
 	// set environment variable
 	_ = os.Setenv(schema.EnvConfigPath, "./conf/conf.yaml")
-	app, config := newApp()
+	app, _, repo, rt := newApp()
+	t.Cleanup(func() { _ = rt.close() })
 	e := httptest.New(t, app)
 
-	repo := db.NewRepoDrones(config)
-
 	isPopulated := repo.IsPopulated()
 	if !isPopulated {
 		// populate database
@@ -39,6 +83,7 @@ func TestNewApp(t *testing.T) {
 	// without basic auth
 	e.GET("/api/v1/drones").Expect().Status(httptest.StatusUnauthorized)
 	e.GET("/api/v1/medications").Expect().Status(httptest.StatusUnauthorized)
+	e.GET("/api/v1/eventlog").Expect().Status(httptest.StatusUnauthorized)
 
 	// with valid JWT auth
 	cred := dto.UserCredIn{
@@ -97,3 +142,667 @@ func TestNewApp(t *testing.T) {
 		t.Errorf("medication %s must be valid", medicationValid.Code)
 	}
 }
+
+func TestTransitionADrone(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, repo, _, auth := newTestServer(t)
+
+	drone := registerDrone(t, e, auth, dto.Heavyweight)
+	lowBattery := dto.RequestDrone{SerialNumber: lib.GenerateUUIDStr(), Model: dto.Heavyweight, BatteryCapacity: 10, State: dto.IDLE}
+	e.POST("/api/v1/drones").WithHeader("Authorization", auth).WithJSON(lowBattery).Expect().Status(httptest.StatusNoContent)
+	medication := lightestMedication(t, repo)
+
+	// a drone enters LOADING only with medication items and enough battery, as when it is loaded
+	e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestTransition{State: dto.LOADING}).Expect().Status(httptest.StatusPreconditionFailed).
+		Body().Contains(schema.ErrDroneNotLoadedKey)
+	e.POST("/api/v1/drones/"+lowBattery.SerialNumber+"/transitions").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestTransition{State: dto.LOADING}).Expect().Status(httptest.StatusPreconditionFailed).
+		Body().Contains(schema.ErrDroneVeryLowBatteryKey)
+	e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestTransition{State: dto.LOADED}).Expect().Status(httptest.StatusPreconditionFailed).
+		Body().Contains(schema.ErrDroneStateTransitionKey)
+	// a loaded drone goes through its delivery back to IDLE
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON([]string{medication.Code}).Expect().Status(httptest.StatusNoContent)
+	for _, state := range []dto.DroneState{dto.DELIVERING, dto.DELIVERED, dto.RETURNING, dto.IDLE} {
+		e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).
+			WithJSON(dto.RequestTransition{State: state}).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("state", state)
+	}
+}
+
+func TestLoadMedicationItemsConcurrently(t *testing.T) {
+	// the populated drones fill the fleet cap of the configuration, environment variables override it
+	t.Setenv("FleetMaxDrones", "0")
+	e, repo, _, auth := newTestServer(t)
+
+	// a fresh IDLE drone able to carry any medication up to 500gr
+	drone := registerDrone(t, e, auth, dto.Heavyweight)
+
+	// the lightest medication, a Heavyweight drone can carry several units of it
+	medication := lightestMedication(t, repo)
+	code, weight := medication.Code, medication.Weight
+	if 3*weight > dto.WeightLimitDrone {
+		t.Fatalf("there is no medication that a Heavyweight drone can carry three times")
+	}
+
+	// hammer the endpoint, only one request can load the drone
+	const requests = 20
+	var wg sync.WaitGroup
+	statuses := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).WithJSON([]string{code}).Expect()
+			statuses <- res.Raw().StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	loaded := 0
+	for status := range statuses {
+		switch status {
+		case httptest.StatusNoContent:
+			loaded++
+		case httptest.StatusPreconditionFailed:
+		default:
+			t.Errorf("unexpected status %d loading the drone", status)
+		}
+	}
+	if loaded != 1 {
+		t.Errorf("the drone must be loaded exactly once, it was loaded %d times", loaded)
+	}
+
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Object().ValueEqual("state", dto.LOADED)
+	e.GET("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Object().
+		ValueEqual("items", []dto.MedicationItem{{Code: code, Quantity: 1}}).
+		ValueEqual("totalWeight", weight)
+
+	// appending an already loaded item adds up its quantity
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithQuery("append", true).WithHeader("Authorization", auth).
+		WithJSON([]dto.MedicationItem{{Code: code, Quantity: 2}}).Expect().Status(httptest.StatusNoContent)
+	e.GET("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Object().
+		ValueEqual("items", []dto.MedicationItem{{Code: code, Quantity: 3}}).
+		ValueEqual("totalWeight", 3*weight)
+
+	// the quantities count towards the weight limit
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithQuery("append", true).WithHeader("Authorization", auth).
+		WithJSON([]dto.MedicationItem{{Code: code, Quantity: dto.WeightLimitDrone}}).Expect().Status(httptest.StatusPreconditionFailed)
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithQuery("append", true).WithHeader("Authorization", auth).
+		WithJSON([]dto.MedicationItem{{Code: code, Quantity: 0}}).Expect().Status(httptest.StatusBadRequest)
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithQuery("append", true).WithHeader("Authorization", auth).
+		WithJSON([]dto.MedicationItem{{Code: code, Quantity: -1}}).Expect().Status(httptest.StatusBadRequest).Body().Contains(schema.ErrValidationField)
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithQuery("append", true).WithHeader("Authorization", auth).
+		WithJSON([]dto.MedicationItem{{Code: "bad code", Quantity: 1}}).Expect().Status(httptest.StatusBadRequest)
+
+	// removing the last item moves the drone back to IDLE
+	e.DELETE("/api/v1/medications/items/"+drone.SerialNumber+"/"+code).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Object().ValueEqual("state", dto.IDLE)
+	e.DELETE("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+
+	// a full unload also moves the drone back to IDLE
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).WithJSON([]string{code}).Expect().Status(httptest.StatusNoContent)
+	e.DELETE("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Object().ValueEqual("state", dto.IDLE)
+
+	// an invalid serial number is a client error
+	invalid := strings.Repeat("X", 101)
+	e.DELETE("/api/v1/medications/items/"+invalid+"/"+code).WithHeader("Authorization", auth).Expect().Status(httptest.StatusBadRequest)
+	e.DELETE("/api/v1/medications/items/"+invalid).WithHeader("Authorization", auth).Expect().Status(httptest.StatusBadRequest)
+}
+
+func TestMedicationsCatalogue(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, _, _, auth := newTestServer(t)
+
+	code := "MED_" + strings.ToUpper(strings.ReplaceAll(lib.GenerateUUIDStr(), "-", "_"))
+	medication := dto.Medication{
+		Name:   "Ibuprofen",
+		Weight: 20,
+		Image:  base64.StdEncoding.EncodeToString([]byte("image")),
+	}
+
+	e.POST("/api/v1/medications/" + code).WithJSON(medication).Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/api/v1/medications/"+code).WithHeader("Authorization", auth).WithJSON(medication).Expect().Status(httptest.StatusCreated)
+	e.POST("/api/v1/medications/"+code).WithHeader("Authorization", auth).WithJSON(medication).Expect().Status(httptest.StatusConflict)
+	e.POST("/api/v1/medications/lower-case").WithHeader("Authorization", auth).WithJSON(medication).Expect().Status(httptest.StatusBadRequest)
+
+	medication.Weight = 25
+	e.PUT("/api/v1/medications/"+code).WithHeader("Authorization", auth).WithJSON(medication).Expect().Status(httptest.StatusNoContent)
+	e.PUT("/api/v1/medications/"+code+"_NONE").WithHeader("Authorization", auth).WithJSON(medication).Expect().Status(httptest.StatusPreconditionFailed)
+
+	// a medication loaded on a drone can not be deleted
+	drone := registerDrone(t, e, auth, dto.Lightweight)
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON([]dto.MedicationItem{{Code: code, Quantity: 2}}).Expect().Status(httptest.StatusNoContent)
+	e.GET("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Object().ValueEqual("totalWeight", 50)
+	e.DELETE("/api/v1/medications/"+code).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+
+	e.DELETE("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.DELETE("/api/v1/medications/"+code).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.DELETE("/api/v1/medications/"+code).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+}
+
+func TestDecommissionADrone(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, repo, _, auth := newTestServer(t)
+
+	drone := registerDrone(t, e, auth, dto.Heavyweight)
+	medication := lightestMedication(t, repo)
+
+	// a drone that is not IDLE can not be decommissioned
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON([]string{medication.Code}).Expect().Status(httptest.StatusNoContent)
+	e.DELETE("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+	e.DELETE("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+
+	e.DELETE("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.DELETE("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+	e.DELETE("/api/v1/drones/"+strings.Repeat("X", 101)).WithHeader("Authorization", auth).Expect().Status(httptest.StatusBadRequest)
+
+	// the tombstone is kept with the decommission date
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Object().Value("decommissioned").String().NotEmpty()
+	e.GET("/api/v1/drones").WithQuery("limit", 500).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Path("$.drones[*].serialNumber").Array().NotContains(drone.SerialNumber)
+	e.GET("/api/v1/drones").WithQuery("limit", 500).WithQuery("includeDecommissioned", true).WithHeader("Authorization", auth).Expect().
+		Status(httptest.StatusOK).JSON().Path("$.drones[*].serialNumber").Array().Contains(drone.SerialNumber)
+
+	// a decommissioned drone can not be used anymore
+	e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestTransition{State: dto.LOADING}).Expect().Status(httptest.StatusPreconditionFailed)
+	e.POST("/api/v1/drones").WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusConflict)
+	e.PATCH("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON(map[string]interface{}{"batteryCapacity": 50}).Expect().Status(httptest.StatusPreconditionFailed)
+}
+
+func TestReplaceAndPatchADrone(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, _, _, auth := newTestServer(t)
+
+	drone := dto.RequestDrone{
+		SerialNumber:    lib.GenerateUUIDStr(),
+		Model:           dto.Lightweight,
+		BatteryCapacity: 100,
+		State:           dto.IDLE,
+	}
+	e.PUT("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusPreconditionFailed)
+	e.POST("/api/v1/drones").WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusNoContent)
+	// an existing drone is not overwritten
+	e.POST("/api/v1/drones").WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusConflict)
+
+	// the weight limit is recomputed from the model
+	drone.Model = dto.Heavyweight
+	drone.BatteryCapacity = 80
+	obj := e.PUT("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusOK).JSON().Object()
+	obj.ValueEqual("model", dto.Heavyweight).ValueEqual("batteryCapacity", 80).ValueEqual("weightLimit", lib.CalculateDroneWeightLimit(dto.Heavyweight))
+	drone.State = dto.DELIVERING
+	e.PUT("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusPreconditionFailed)
+
+	// only the given fields are updated
+	obj = e.PATCH("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON(map[string]interface{}{"model": dto.Middleweight, "weightLimit": 1000}).Expect().Status(httptest.StatusOK).JSON().Object()
+	obj.ValueEqual("model", dto.Middleweight).ValueEqual("batteryCapacity", 80).ValueEqual("state", dto.IDLE).
+		ValueEqual("weightLimit", lib.CalculateDroneWeightLimit(dto.Middleweight))
+	e.PATCH("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON(map[string]interface{}{"batteryCapacity": 101}).Expect().Status(httptest.StatusBadRequest)
+	e.PATCH("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON(map[string]interface{}{"batteryCapacity": nil}).Expect().Status(httptest.StatusBadRequest)
+	e.PATCH("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON(map[string]interface{}{"state": dto.RETURNING}).Expect().Status(httptest.StatusPreconditionFailed)
+
+	// as the transitions, a drone enters LOADING only with medication items and enough battery
+	e.PATCH("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON(map[string]interface{}{"state": dto.LOADING}).Expect().Status(httptest.StatusPreconditionFailed).
+		Body().Contains(schema.ErrDroneNotLoadedKey)
+	e.PATCH("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).
+		WithJSON(map[string]interface{}{"state": dto.LOADING, "batteryCapacity": 5}).Expect().Status(httptest.StatusPreconditionFailed).
+		Body().Contains(schema.ErrDroneVeryLowBatteryKey)
+	drone.State = dto.LOADING
+	e.PUT("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusPreconditionFailed).
+		Body().Contains(schema.ErrDroneNotLoadedKey)
+}
+
+func TestDroneBatteryLevel(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, _, rt, auth := newTestServer(t)
+
+	e.GET("/api/v1/drones/"+lib.GenerateUUIDStr()+"/battery").WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+	drone := registerDrone(t, e, auth, dto.Lightweight)
+	e.GET("/api/v1/drones/"+drone.SerialNumber+"/battery").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("serialNumber", drone.SerialNumber).ValueEqual("batteryCapacity", 100)
+
+	// the level must be in the range 0 - 100
+	e.PUT("/api/v1/drones/"+drone.SerialNumber+"/battery").WithHeader("Authorization", auth).WithJSON(map[string]interface{}{"batteryCapacity": 101}).Expect().Status(httptest.StatusBadRequest)
+	e.PUT("/api/v1/drones/"+drone.SerialNumber+"/battery").WithHeader("Authorization", auth).WithJSON(map[string]interface{}{"batteryCapacity": -1}).Expect().Status(httptest.StatusBadRequest)
+	e.PUT("/api/v1/drones/"+drone.SerialNumber+"/battery").WithHeader("Authorization", auth).WithJSON(map[string]interface{}{}).Expect().Status(httptest.StatusBadRequest)
+
+	e.PUT("/api/v1/drones/"+drone.SerialNumber+"/battery").WithHeader("Authorization", auth).WithJSON(map[string]interface{}{"batteryCapacity": 0}).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("batteryCapacity", 0)
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("batteryCapacity", 0)
+
+	// the reported level is in the event log
+	logEvent := e.GET("/api/v1/eventlog").WithHeader("Authorization", auth).WithQuery("serialNumber", drone.SerialNumber).
+		Expect().Status(httptest.StatusOK).JSON().Array().First().Object()
+	logEvent.ValueEqual("source", dto.LogEventSourceDrone)
+	logEvent.Value("dronesBatteryLevels").Array().Length().Equal(1)
+	logEvent.Value("dronesBatteryLevels").Array().First().Object().ValueEqual("batteryCapacity", 0)
+
+	// a failure writing the event log does not fail the saved battery level
+	if err := rt.repoEventLog.Close(); err != nil {
+		t.Fatalf("error closing the event log: %v", err)
+	}
+	e.PUT("/api/v1/drones/"+drone.SerialNumber+"/battery").WithHeader("Authorization", auth).WithJSON(map[string]interface{}{"batteryCapacity": 50}).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("batteryCapacity", 50)
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("batteryCapacity", 50)
+
+	// a decommissioned drone can not report its battery level
+	e.DELETE("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.PUT("/api/v1/drones/"+drone.SerialNumber+"/battery").WithHeader("Authorization", auth).WithJSON(map[string]interface{}{"batteryCapacity": 50}).Expect().Status(httptest.StatusPreconditionFailed)
+}
+
+func TestIngestTelemetry(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, _, rt, auth := newTestServer(t)
+
+	serialNumbers := []string{registerDrone(t, e, auth, dto.Lightweight).SerialNumber, registerDrone(t, e, auth, dto.Lightweight).SerialNumber}
+
+	e.POST("/api/v1/drones/telemetry").WithHeader("Authorization", auth).WithJSON([]interface{}{}).Expect().Status(httptest.StatusBadRequest)
+
+	telemetry := []map[string]interface{}{
+		{"serialNumber": serialNumbers[0], "batteryCapacity": 70, "state": dto.LOADING},    // without medication items
+		{"serialNumber": serialNumbers[1], "batteryCapacity": 101, "state": dto.IDLE},      // invalid battery level
+		{"serialNumber": serialNumbers[1], "batteryCapacity": 60, "state": dto.DELIVERING}, // illegal state transition
+		{"serialNumber": lib.GenerateUUIDStr(), "batteryCapacity": 50, "state": dto.IDLE},  // unknown drone
+		{"serialNumber": serialNumbers[1], "batteryCapacity": 40, "state": dto.IDLE},
+	}
+	results := e.POST("/api/v1/drones/telemetry").WithHeader("Authorization", auth).WithJSON(telemetry).Expect().Status(httptest.StatusOK).JSON().Array()
+	results.Length().Equal(len(telemetry))
+	for i, status := range []int{httptest.StatusPreconditionFailed, httptest.StatusBadRequest, httptest.StatusPreconditionFailed, httptest.StatusPreconditionFailed, httptest.StatusOK} {
+		result := results.Element(i).Object()
+		result.ValueEqual("serialNumber", telemetry[i]["serialNumber"]).ValueEqual("status", status)
+		if status == httptest.StatusOK {
+			result.NotContainsKey("problem")
+		} else {
+			result.Value("problem").Object().ValueEqual("Status", status)
+		}
+	}
+
+	e.GET("/api/v1/drones/"+serialNumbers[0]).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("batteryCapacity", 100).ValueEqual("state", dto.IDLE)
+	e.GET("/api/v1/drones/"+serialNumbers[1]).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("batteryCapacity", 40).ValueEqual("state", dto.IDLE)
+
+	// the applied battery levels are in the event log
+	e.GET("/api/v1/eventlog").WithHeader("Authorization", auth).WithQuery("serialNumber", serialNumbers[1]).
+		Expect().Status(httptest.StatusOK).JSON().Array().First().Object().ValueEqual("source", dto.LogEventSourceTelemetry)
+
+	// a failure writing the event log does not lose the results of the saved telemetry
+	if err := rt.repoEventLog.Close(); err != nil {
+		t.Fatalf("error closing the event log: %v", err)
+	}
+	results = e.POST("/api/v1/drones/telemetry").WithHeader("Authorization", auth).
+		WithJSON([]map[string]interface{}{{"serialNumber": serialNumbers[1], "batteryCapacity": 30, "state": dto.IDLE}}).Expect().Status(httptest.StatusOK).JSON().Array()
+	results.Length().Equal(1)
+	results.First().Object().ValueEqual("status", httptest.StatusOK)
+	e.GET("/api/v1/drones/"+serialNumbers[1]).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("batteryCapacity", 30)
+}
+
+func TestOrders(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, repo, _, auth := newTestServer(t)
+
+	drone := registerDrone(t, e, auth, dto.Heavyweight)
+
+	medications, err := repo.GetMedications()
+	if err != nil {
+		t.Fatalf("error getting the medications: %v", err)
+	}
+	// the lightest medication, a Heavyweight drone can carry it
+	var code string
+	var weight float64
+	for _, medication := range *medications {
+		if code == "" || medication.Weight < weight {
+			code, weight = medication.Code, medication.Weight
+		}
+	}
+
+	request := dto.RequestOrder{SerialNumber: drone.SerialNumber, Destination: "Calle 23, Vedado", Items: []dto.MedicationItem{{Code: code, Quantity: 1}}}
+	e.POST("/api/v1/orders").WithHeader("Authorization", auth).WithJSON(dto.RequestOrder{SerialNumber: drone.SerialNumber, Items: request.Items}).
+		Expect().Status(httptest.StatusBadRequest)
+	order := e.POST("/api/v1/orders").WithHeader("Authorization", auth).WithJSON(request).Expect().Status(httptest.StatusCreated).JSON().Object()
+	order.ValueEqual("serialNumber", drone.SerialNumber).ValueEqual("destination", request.Destination).ValueEqual("status", dto.OrderLoaded)
+	id := order.Value("id").String().Raw()
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("state", dto.LOADED)
+	// the drone is busy with the order
+	e.POST("/api/v1/orders").WithHeader("Authorization", auth).WithJSON(request).Expect().Status(httptest.StatusPreconditionFailed)
+
+	// the order advances with the drone state
+	e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).WithJSON(dto.RequestTransition{State: dto.DELIVERING}).Expect().Status(httptest.StatusOK)
+	e.GET("/api/v1/orders/"+id).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("status", dto.OrderDelivering)
+	e.DELETE("/api/v1/orders/"+id).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+	e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).WithJSON(dto.RequestTransition{State: dto.DELIVERED}).Expect().Status(httptest.StatusOK)
+	e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).WithJSON(dto.RequestTransition{State: dto.RETURNING}).Expect().Status(httptest.StatusOK)
+	e.POST("/api/v1/drones/"+drone.SerialNumber+"/transitions").WithHeader("Authorization", auth).WithJSON(dto.RequestTransition{State: dto.IDLE}).Expect().Status(httptest.StatusOK)
+	e.GET("/api/v1/orders/"+id).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("status", dto.OrderDelivered)
+	e.DELETE("/api/v1/orders/"+id).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+
+	// loading the drone directly goes through an order too, cancelling it unloads the drone
+	e.POST("/api/v1/medications/items/"+drone.SerialNumber).WithHeader("Authorization", auth).WithJSON([]string{code}).Expect().Status(httptest.StatusNoContent)
+	orders := e.GET("/api/v1/orders").WithHeader("Authorization", auth).WithQuery("serialNumber", drone.SerialNumber).WithQuery("status", int(dto.OrderLoaded)).
+		Expect().Status(httptest.StatusOK).JSON().Array()
+	orders.Length().Equal(1)
+	id = orders.First().Object().ValueEqual("destination", "").Value("id").String().Raw()
+	e.DELETE("/api/v1/orders/"+id).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.GET("/api/v1/orders/"+id).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("status", dto.OrderCancelled)
+	e.GET("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("state", dto.IDLE)
+	e.GET("/api/v1/orders").WithHeader("Authorization", auth).WithQuery("serialNumber", drone.SerialNumber).
+		Expect().Status(httptest.StatusOK).JSON().Array().Length().Equal(2)
+	e.GET("/api/v1/orders/"+lib.GenerateUUIDStr()).WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+}
+
+func TestDispatch(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, repo, _, auth := newTestServer(t)
+
+	// an IDLE drone able to carry any medication
+	registerDrone(t, e, auth, dto.Heavyweight)
+
+	medications, err := repo.GetMedications()
+	if err != nil {
+		t.Fatalf("error getting the medications: %v", err)
+	}
+	// the lightest medication, a Heavyweight drone can carry it
+	var code string
+	var weight float64
+	for _, medication := range *medications {
+		if code == "" || medication.Weight < weight {
+			code, weight = medication.Code, medication.Weight
+		}
+	}
+
+	e.POST("/api/v1/dispatch").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestDispatch{Items: []dto.MedicationItem{{Code: code, Quantity: 1}}, Strategy: "nearest"}).Expect().Status(httptest.StatusBadRequest)
+	e.POST("/api/v1/dispatch").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestDispatch{Items: []dto.MedicationItem{{Code: "UNKNOWN_01", Quantity: 1}}}).Expect().Status(httptest.StatusPreconditionFailed)
+	// no drone can carry the payload
+	e.POST("/api/v1/dispatch").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestDispatch{Items: []dto.MedicationItem{{Code: code, Quantity: 1000}}}).Expect().Status(httptest.StatusPreconditionFailed).
+		Body().Contains(schema.ErrDroneMaximumLoadWeightExceededKey)
+
+	dispatch := e.POST("/api/v1/dispatch").WithHeader("Authorization", auth).
+		WithJSON(dto.RequestDispatch{Destination: "Calle 23, Vedado", Items: []dto.MedicationItem{{Code: code, Quantity: 1}}, Strategy: dto.DispatchHighestBattery}).
+		Expect().Status(httptest.StatusCreated).JSON().Object()
+	dispatch.ValueEqual("strategy", dto.DispatchHighestBattery)
+	picked := dispatch.Value("drone").Object().ValueEqual("state", dto.LOADED).ValueEqual("batteryCapacity", 100).Value("serialNumber").String().Raw()
+	dispatch.Value("order").Object().ValueEqual("serialNumber", picked).ValueEqual("destination", "Calle 23, Vedado").ValueEqual("status", dto.OrderLoaded)
+	e.GET("/api/v1/drones/"+picked).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("state", dto.LOADED)
+}
+
+func TestPlanDispatch(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, repo, _, auth := newTestServer(t)
+
+	// two IDLE drones, together they can carry the payload
+	for i := 0; i < 2; i++ {
+		registerDrone(t, e, auth, dto.Heavyweight)
+	}
+
+	medications, err := repo.GetMedications()
+	if err != nil {
+		t.Fatalf("error getting the medications: %v", err)
+	}
+	var code string
+	var weight float64
+	for _, medication := range *medications {
+		if code == "" || medication.Weight < weight {
+			code, weight = medication.Code, medication.Weight
+		}
+	}
+	// the payload is heavier than a single drone can carry
+	quantity := int(dto.WeightLimitDrone/weight) + 1
+	request := dto.RequestDispatchPlan{Destination: "Calle 23, Vedado", Items: []dto.MedicationItem{{Code: code, Quantity: quantity}}}
+
+	e.POST("/api/v1/dispatch").WithHeader("Authorization", auth).WithJSON(dto.RequestDispatch{Items: request.Items}).Expect().Status(httptest.StatusPreconditionFailed)
+	e.POST("/api/v1/dispatch/plan").WithHeader("Authorization", auth).WithQuery("commit", "maybe").WithJSON(request).Expect().Status(httptest.StatusBadRequest)
+
+	checkPlan := func(plan map[string]interface{}) []interface{} {
+		if plan["payloadWeight"].(float64) != weight*float64(quantity) {
+			t.Errorf("the payload must weigh %.2f, got %v", weight*float64(quantity), plan["payloadWeight"])
+		}
+		loads := plan["loads"].([]interface{})
+		if len(loads) < 2 {
+			t.Fatalf("the payload must be split across several drones, got %d loads", len(loads))
+		}
+		units := 0
+		for _, load := range loads {
+			load := load.(map[string]interface{})
+			if load["weight"].(float64) > load["weightLimit"].(float64) {
+				t.Errorf("the load of %s exceeds its weight limit", load["serialNumber"])
+			}
+			for _, item := range load["items"].([]interface{}) {
+				units += int(item.(map[string]interface{})["quantity"].(float64))
+			}
+		}
+		if units != quantity {
+			t.Errorf("the plan must carry %d units, got %d", quantity, units)
+		}
+		return loads
+	}
+
+	// a proposal does not load any drone
+	plan := e.POST("/api/v1/dispatch/plan").WithHeader("Authorization", auth).WithJSON(request).Expect().Status(httptest.StatusOK).JSON().Object()
+	plan.ValueEqual("committed", false)
+	for _, load := range checkPlan(plan.Raw()) {
+		serialNumber := load.(map[string]interface{})["serialNumber"].(string)
+		e.GET("/api/v1/drones/"+serialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("state", dto.IDLE)
+	}
+
+	plan = e.POST("/api/v1/dispatch/plan").WithHeader("Authorization", auth).WithQuery("commit", true).WithJSON(request).Expect().Status(httptest.StatusCreated).JSON().Object()
+	plan.ValueEqual("committed", true)
+	for _, load := range checkPlan(plan.Raw()) {
+		serialNumber := load.(map[string]interface{})["serialNumber"].(string)
+		if load.(map[string]interface{})["order"] == nil {
+			t.Errorf("the drone %s of a committed plan must have an order", serialNumber)
+		}
+		e.GET("/api/v1/drones/"+serialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("state", dto.LOADED)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	e, _, _, auth := newTestServer(t)
+
+	e.GET("/api/v1/users").Expect().Status(httptest.StatusUnauthorized)
+
+	// the populated users are keyed by username, the passphrases are never returned
+	users := e.GET("/api/v1/users").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Array()
+	users.Length().Ge(2)
+	users.First().Object().NotContainsKey("passphrase")
+	e.GET("/api/v1/auth/user").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("username", "richard.sargon@meinermail.com").NotContainsKey("passphrase")
+
+	request := dto.RequestUser{
+		Username: gofakeit.Username() + "@meinermail.com",
+		Password: "password3",
+		Name:     "Jane Doe",
+		Role:     dto.RoleViewer,
+	}
+	e.POST("/api/v1/users").WithHeader("Authorization", auth).WithJSON(request).Expect().Status(httptest.StatusCreated).
+		JSON().Object().ValueEqual("username", request.Username).ValueEqual("disabled", false).NotContainsKey("passphrase")
+	e.POST("/api/v1/users").WithHeader("Authorization", auth).WithJSON(request).Expect().Status(httptest.StatusConflict)
+	e.POST("/api/v1/users").WithHeader("Authorization", auth).WithJSON(dto.RequestUser{Username: "a/b", Password: "password3", Name: "Jane", Role: dto.RoleViewer}).
+		Expect().Status(httptest.StatusBadRequest)
+
+	e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: request.Username, Password: request.Password}).Expect().Status(httptest.StatusOK)
+
+	// a new password replaces the old one
+	password := "password4"
+	e.PATCH("/api/v1/users/"+request.Username).WithHeader("Authorization", auth).WithJSON(dto.RequestUserUpdate{Password: &password}).
+		Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("name", request.Name)
+	e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: request.Username, Password: request.Password}).Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: request.Username, Password: password}).Expect().Status(httptest.StatusOK)
+
+	// a disabled user is kept but it can not authenticate
+	e.DELETE("/api/v1/users/"+request.Username).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+	e.GET("/api/v1/users/"+request.Username).WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).
+		JSON().Object().ValueEqual("disabled", true)
+	e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: request.Username, Password: password}).Expect().Status(httptest.StatusUnauthorized)
+
+	e.GET("/api/v1/users/noexist@meinermail.com").WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+	e.DELETE("/api/v1/users/noexist@meinermail.com").WithHeader("Authorization", auth).Expect().Status(httptest.StatusPreconditionFailed)
+}
+
+func TestLegacyPasswordRehash(t *testing.T) {
+	e, repo, _, _ := newTestServer(t)
+
+	// a user written by an older version, with an unsalted SHA-256 hash
+	checksum, _ := lib.Checksum(lib.SHA256, []byte("password5"))
+	legacy := dto.User{Username: gofakeit.Username() + "@meinermail.com", Passphrase: checksum, Name: "John Doe", Role: dto.RoleViewer}
+	if err := repo.CreateUser(&legacy); err != nil {
+		t.Fatalf("error creating the user: %v", err)
+	}
+
+	e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: legacy.Username, Password: "password6"}).Expect().Status(httptest.StatusUnauthorized)
+	user, err := repo.GetUser(legacy.Username)
+	if err != nil || user.Passphrase != checksum {
+		t.Fatalf("a failed login must keep the legacy hash, got %+v (%v)", user, err)
+	}
+
+	// the legacy hash is replaced on the first successful login
+	e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: legacy.Username, Password: "password5"}).Expect().Status(httptest.StatusOK)
+	user, err = repo.GetUser(legacy.Username)
+	if err != nil || !strings.HasPrefix(user.Passphrase, "$argon2id$v=19$") {
+		t.Fatalf("the legacy hash must be replaced with an argon2id hash, got %+v (%v)", user, err)
+	}
+	if match, rehash, err := lib.VerifyPassword("password5", user.Passphrase); !match || rehash || err != nil {
+		t.Errorf("the new hash must match the password without rehash, got %v %v %v", match, rehash, err)
+	}
+	e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: legacy.Username, Password: "password5"}).Expect().Status(httptest.StatusOK)
+
+	// the hashes of the users are salted
+	other, _ := lib.HashPassword("password5")
+	if other == user.Passphrase {
+		t.Errorf("two hashes of the same password must be different")
+	}
+}
+
+func TestRoles(t *testing.T) {
+	t.Setenv("FleetMaxDrones", "0")
+	e, _, _, admin := newTestServer(t)
+
+	login := func(username, password string) string {
+		accessToken := e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: username, Password: password}).Expect().Status(httptest.StatusOK).JSON().Object().Value("accessToken").String().Raw()
+		return "Bearer " + accessToken
+	}
+	dispatcher := login("tom.carter@meinermail.com", "password2")
+
+	// only an admin manages the users
+	e.GET("/api/v1/users").WithHeader("Authorization", dispatcher).Expect().Status(httptest.StatusForbidden).Body().Contains(schema.ErrUnauthorized)
+	e.POST("/api/v1/users").WithHeader("Authorization", admin).WithJSON(dto.RequestUser{Username: gofakeit.Username() + "@meinermail.com", Password: "password3", Name: "Jane", Role: "root"}).
+		Expect().Status(httptest.StatusBadRequest)
+
+	viewer := dto.RequestUser{Username: gofakeit.Username() + "@meinermail.com", Password: "password3", Name: "Jane Doe", Role: dto.RoleViewer}
+	e.POST("/api/v1/users").WithHeader("Authorization", admin).WithJSON(viewer).Expect().Status(httptest.StatusCreated).JSON().Object().ValueEqual("role", dto.RoleViewer)
+	auth := login(viewer.Username, viewer.Password)
+
+	// a viewer reads, but it can not operate the fleet
+	drone := dto.RequestDrone{SerialNumber: lib.GenerateUUIDStr(), Model: dto.Lightweight, BatteryCapacity: 100, State: dto.IDLE}
+	e.GET("/api/v1/drones").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK)
+	e.GET("/api/v1/orders").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK)
+	e.GET("/api/v1/auth/user").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK).JSON().Object().ValueEqual("role", dto.RoleViewer)
+	e.POST("/api/v1/drones").WithHeader("Authorization", auth).WithJSON(drone).Expect().Status(httptest.StatusForbidden)
+	e.POST("/api/v1/dispatch").WithHeader("Authorization", auth).WithJSON(dto.RequestDispatch{Items: []dto.MedicationItem{{Code: "X", Quantity: 1}}}).Expect().Status(httptest.StatusForbidden)
+	e.GET("/api/v1/users").WithHeader("Authorization", auth).Expect().Status(httptest.StatusForbidden)
+
+	// only an authenticated admin populates the database
+	e.POST("/api/v1/database/populate").Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/api/v1/database/populate").WithHeader("Authorization", auth).Expect().Status(httptest.StatusForbidden).Body().Contains(schema.ErrUnauthorized)
+	e.POST("/api/v1/database/populate").WithHeader("Authorization", dispatcher).Expect().Status(httptest.StatusForbidden).Body().Contains(schema.ErrUnauthorized)
+	e.POST("/api/v1/database/populate").WithHeader("Authorization", admin).Expect().Status(httptest.StatusInternalServerError).Body().Contains(schema.ErrBuntdbPopulated)
+
+	// a dispatcher operates the fleet
+	e.POST("/api/v1/drones").WithHeader("Authorization", dispatcher).WithJSON(drone).Expect().Status(httptest.StatusNoContent)
+
+	// the role is read from the access token, a new role applies after a new login
+	role := dto.RoleDispatcher
+	e.PATCH("/api/v1/users/"+viewer.Username).WithHeader("Authorization", admin).WithJSON(dto.RequestUserUpdate{Role: &role}).Expect().Status(httptest.StatusOK)
+	e.DELETE("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusForbidden)
+	auth = login(viewer.Username, viewer.Password)
+	e.DELETE("/api/v1/drones/"+drone.SerialNumber).WithHeader("Authorization", auth).Expect().Status(httptest.StatusNoContent)
+}
+
+func TestScopes(t *testing.T) {
+	e, _, _, _ := newTestServer(t)
+
+	login := func(scope string) *httptest.Request {
+		return e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: "tom.carter@meinermail.com", Password: "password2", Scope: scope})
+	}
+
+	// the dispatcher is not allowed the admin scopes, they are trimmed
+	accessToken := login("drones:read orders:write admin:users unknown:scope").Expect().Status(httptest.StatusOK).JSON().Object().Value("accessToken").String().Raw()
+	auth := "Bearer " + accessToken
+	e.GET("/api/v1/drones").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK)
+	e.GET("/api/v1/alerts").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK)
+	e.POST("/api/v1/drones").WithHeader("Authorization", auth).WithJSON(dto.RequestDrone{SerialNumber: lib.GenerateUUIDStr(), Model: dto.Lightweight, BatteryCapacity: 100, State: dto.IDLE}).
+		Expect().Status(httptest.StatusForbidden).Body().Contains("drones:write")
+	e.GET("/api/v1/medications").WithHeader("Authorization", auth).Expect().Status(httptest.StatusForbidden).Body().Contains("medications:read")
+	e.GET("/api/v1/orders").WithHeader("Authorization", auth).Expect().Status(httptest.StatusForbidden).Body().Contains("orders:read")
+
+	// none of the requested scopes is allowed
+	login("admin:users admin:database").Expect().Status(httptest.StatusForbidden).Body().Contains(schema.ErrUnauthorized)
+
+	// all the scopes allowed to the role are granted when none is requested
+	auth = "Bearer " + login("").Expect().Status(httptest.StatusOK).JSON().Object().Value("accessToken").String().Raw()
+	e.GET("/api/v1/medications").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK)
+	e.GET("/api/v1/orders").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK)
+
+	// an admin without the database scope can not populate the database
+	admin := e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: "richard.sargon@meinermail.com", Password: "password1", Scope: "drones:read"}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("accessToken").String().Raw()
+	e.POST("/api/v1/database/populate").WithHeader("Authorization", "Bearer "+admin).Expect().Status(httptest.StatusForbidden).Body().Contains("admin:database")
+}
+
+func TestRefreshTokens(t *testing.T) {
+	e, _, _, admin := newTestServer(t)
+
+	tokens := e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: "tom.carter@meinermail.com", Password: "password2", Scope: "drones:read"}).
+		Expect().Status(httptest.StatusOK).JSON().Object()
+	tokens.ValueEqual("tokenType", "Bearer").ValueEqual("expiresIn", 15*60).ValueEqual("scope", "drones:read")
+	refreshToken := tokens.Value("refreshToken").String().NotEmpty().Raw()
+
+	// the refresh token is rotated, the new access token keeps the scopes
+	refreshed := e.POST("/api/v1/auth/refresh").WithJSON(dto.RequestRefresh{RefreshToken: refreshToken}).Expect().Status(httptest.StatusOK).JSON().Object()
+	refreshed.ValueEqual("scope", "drones:read")
+	rotated := refreshed.Value("refreshToken").String().NotEqual(refreshToken).Raw()
+	auth := "Bearer " + refreshed.Value("accessToken").String().Raw()
+	e.GET("/api/v1/drones").WithHeader("Authorization", auth).Expect().Status(httptest.StatusOK)
+	e.GET("/api/v1/orders").WithHeader("Authorization", auth).Expect().Status(httptest.StatusForbidden)
+
+	// replaying a used refresh token revokes its family
+	e.POST("/api/v1/auth/refresh").WithJSON(dto.RequestRefresh{RefreshToken: refreshToken}).Expect().Status(httptest.StatusUnauthorized).Body().Contains(schema.ErrUnauthorized)
+	e.POST("/api/v1/auth/refresh").WithJSON(dto.RequestRefresh{RefreshToken: rotated}).Expect().Status(httptest.StatusUnauthorized)
+
+	e.POST("/api/v1/auth/refresh").WithJSON(dto.RequestRefresh{RefreshToken: "invalid"}).Expect().Status(httptest.StatusUnauthorized)
+	e.POST("/api/v1/auth/refresh").WithJSON(dto.RequestRefresh{}).Expect().Status(httptest.StatusBadRequest)
+
+	// the refresh tokens of a disabled user are rejected
+	user := dto.RequestUser{Username: gofakeit.Username() + "@meinermail.com", Password: "password3", Name: "Jane Doe", Role: dto.RoleViewer}
+	e.POST("/api/v1/users").WithHeader("Authorization", admin).WithJSON(user).Expect().Status(httptest.StatusCreated)
+	refreshToken = e.POST("/api/v1/auth").WithJSON(dto.UserCredIn{Username: user.Username, Password: user.Password}).
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("refreshToken").String().Raw()
+	e.DELETE("/api/v1/users/"+user.Username).WithHeader("Authorization", admin).Expect().Status(httptest.StatusNoContent)
+	e.POST("/api/v1/auth/refresh").WithJSON(dto.RequestRefresh{RefreshToken: refreshToken}).Expect().Status(httptest.StatusUnauthorized)
+}
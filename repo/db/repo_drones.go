@@ -2,6 +2,7 @@ package db
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -14,7 +15,7 @@ import (
 	"restapi.app/schema/dto"
 	"restapi.app/service/utils"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // region ======== SETUP =================================================================
@@ -22,82 +23,137 @@ import (
 type RepoDrones interface {
 	IsPopulated() bool
 	PopulateDB() error
+	Close() error
 
-	GetUser(field string, filterOptional ...bool) (*dto.User, error)
+	GetUser(username string) (*dto.User, error)
 	GetUsers() (*[]dto.User, error)
+	CreateUser(user *dto.User) error
+	UpdateUser(username string, update func(user *dto.User) error) (*dto.User, error)
+
+	CreateRefreshToken(token *dto.RefreshToken, ttl time.Duration) error
+	RotateRefreshToken(id string, ttl time.Duration, rotate func(current *dto.RefreshToken) (*dto.RefreshToken, error)) (*dto.RefreshToken, error)
+	RevokeRefreshTokens(family string) error
 
 	GetDrone(serialNumber string) (*dto.Drone, error)
-	GetDrones(filter string) (*[]dto.Drone, error)
-	RegisterDrone(drone *dto.Drone) error
-	CheckingLoadedMedicationsItems(serialNumber string) (*[]string, error)
-	LoadMedicationItemsADrone(drone *dto.Drone, medicationItemIDs []interface{}) error
+	GetDrones(filter *dto.DroneFilter) (*dto.DronesPage, error)
+	RegisterDrone(drone *dto.Drone, maxDrones int) error
+	GetLoadableDrones(medicationItems []dto.MedicationItem, payloadWeight float64, canLoad func(drone *dto.Drone) error) (*[]dto.AvailableDrone, error)
+	UpdateDrone(serialNumber string, update func(drone *dto.Drone, loaded []dto.MedicationItem) (*dto.Drone, error)) (*dto.Drone, error)
+	DecommissionDrone(serialNumber string, decommissioned string, canDecommission func(drone *dto.Drone) error) error
+	UpdateDroneState(serialNumber string, state dto.DroneState, canMove func(drone *dto.Drone, state dto.DroneState, loaded []dto.MedicationItem) error) (*dto.Drone, error)
+	ApplyTelemetry(telemetry []dto.RequestTelemetry, apply func(drone *dto.Drone, loaded []dto.MedicationItem, telemetry *dto.RequestTelemetry) error) ([]error, error)
+	CheckingLoadedMedicationsItems(serialNumber string) (*dto.LoadedMedications, error)
+	LoadMedicationItemsADrone(serialNumber string, medicationItems []dto.MedicationItem, appendItems bool, canLoad func(drone *dto.Drone) error) error
+	RemoveMedicationItemADrone(serialNumber string, code string, canUnload func(drone *dto.Drone) error) error
+	UnloadMedicationItemsADrone(serialNumber string, canUnload func(drone *dto.Drone) error) error
 	ExistDrone(serialNumber string) error
 
 	GetMedications() (*[]dto.Medication, error)
+	AddMedication(medication *dto.Medication) error
+	UpdateMedication(medication *dto.Medication) error
+	DeleteMedication(code string) error
+
+	GetOrders(filter *dto.OrderFilter) (*[]dto.Order, error)
+	GetOrder(id string) (*dto.Order, error)
+	CreateOrder(serialNumber string, destination string, medicationItems []dto.MedicationItem, canLoad func(drone *dto.Drone) error) (*dto.Order, error)
+	CancelOrder(id string, canUnload func(drone *dto.Drone) error) error
+	PlanDispatch(destination string, medicationItems []dto.MedicationItem, commit bool, canLoad func(drone *dto.Drone) error, plan func(candidates []dto.AvailableDrone, weights map[string]float64, items []dto.MedicationItem) ([]dto.PlannedLoad, error)) (*dto.DispatchPlan, error)
+	DispatchOrder(destination string, medicationItems []dto.MedicationItem, canLoad func(drone *dto.Drone) error, pick func(candidates []dto.AvailableDrone) *dto.AvailableDrone) (*dto.Drone, *dto.Order, error)
+
+	GetAlerts(filter *dto.AlertFilter) (*[]dto.Alert, error)
+	UpdateAlert(id string, update func(alert *dto.Alert) error) (*dto.Alert, error)
 }
 
 type repoDrones struct {
-	DBUserLocation string
+	DBUserLocation  string
+	db              *buntdb.DB // long-lived handle, it is shared by every request during the process lifetime
+	alertThresholds []float64  // battery levels that create an alert when a drone drops below them
+	webhooks        []string   // webhooks notified of the new alerts
 }
 
 // endregion =============================================================================
 
-func NewRepoDrones(svcConf *utils.SvcConfig) RepoDrones {
-	return &repoDrones{DBUserLocation: svcConf.StoreDBPath}
+// NewRepoDrones open the store database and create its indexes. The returned repository owns
+// the database handle until Close is called
+func NewRepoDrones(svcConf *utils.SvcConfig) (RepoDrones, error) {
+	log.Println("Load DB ", svcConf.StoreDBPath)
+	// Open the data.db file. It will be created if it doesn't exist.
+	db, err := buntdb.Open(svcConf.StoreDBPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the indexes are created once, buntdb keeps them updated on every write
+	indexes := []struct {
+		name    string
+		pattern string
+		less    func(a, b string) bool
+	}{
+		// custom index: sort users by username
+		{"username", "user:*", buntdb.IndexJSON("username")},
+		// custom index: sort drones by battery capacity
+		{"drone_state", "drone:*", buntdb.IndexJSON("batteryCapacity")},
+		// custom index: sort drones by model
+		{"drone_model", "drone:*", buntdb.IndexJSON("model")},
+		{"loaded_medications", "loaded_medications:*", buntdb.IndexString},
+		// custom index: sort medications by weight
+		{"medication_state", "med:*", buntdb.IndexJSON("weight")},
+		// custom index: sort orders by creation date
+		{"order_created", "order:*", buntdb.IndexJSON("created")},
+		// custom index: sort alerts by creation date
+		{"alert_created", "alert:*", buntdb.IndexJSON("created")},
+	}
+	for _, index := range indexes {
+		if err = db.CreateIndex(index.name, index.pattern, index.less); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	if err = migrateUsers(db, svcConf.BootstrapAdmin); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err = seedUsers(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &repoDrones{
+		DBUserLocation:  svcConf.StoreDBPath,
+		db:              db,
+		alertThresholds: svcConf.AlertThresholds,
+		webhooks:        svcConf.WebhookURLs,
+	}, nil
 }
 
 // region ======== METHODS ===============================================================
 
 func (r *repoDrones) IsPopulated() bool {
-	db, err := r.loadDB()
-	if err != nil {
-		return false
-	}
-	defer db.Close()
-	return isPopulated(db)
+	return isPopulated(r.db)
+}
+
+// Close closes the database handle, it must be called once on shutdown
+func (r *repoDrones) Close() error {
+	log.Println("Close DB ", r.DBUserLocation)
+	return r.db.Close()
 }
 
 // PopulateDB Populate the database with the initial information only if "IsPopulated" is
 // false or does not exist
+//
 //nolint:gocognit
 func (r *repoDrones) PopulateDB() error {
-	db, err := r.loadDB()
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
 	// If it is already populated, the execution of the function stops
-	if isPopulated(db) {
+	if isPopulated(r.db) {
 		return errors.New(schema.ErrBuntdbPopulated)
 	}
 
-	var fakeUsersList = fakeUsers()
 	var fakeDronesList = fakeDrones()
 	var fakeMedicationsList = fakeMedications()
 
-	log.Println("writing users in database")
-	err = db.Update(func(tx *buntdb.Tx) error {
-		for i := 0; i < len(fakeUsersList); i++ {
-			res, err := jsoniter.MarshalToString(fakeUsersList[i])
-			log.Printf("user #%d: %s", i, res)
-			if err != nil {
-				return err
-			}
-			_, _, err = tx.Set(strconv.Itoa(i), res, nil)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	log.Println("successfully added users")
-
 	log.Println("writing drones in database")
-	err = db.Update(func(tx *buntdb.Tx) error {
+	err := r.db.Update(func(tx *buntdb.Tx) error {
 		for i := 0; i < len(fakeDronesList); i++ {
 			res, err := jsoniter.MarshalToString(fakeDronesList[i])
 			if err != nil {
@@ -117,7 +173,7 @@ func (r *repoDrones) PopulateDB() error {
 	log.Println("successfully added drones")
 
 	log.Println("writing medications in database")
-	err = db.Update(func(tx *buntdb.Tx) error {
+	err = r.db.Update(func(tx *buntdb.Tx) error {
 		for i := 0; i < len(fakeMedicationsList); i++ {
 			res, err := jsoniter.MarshalToString(fakeMedicationsList[i])
 			if err != nil {
@@ -137,7 +193,7 @@ func (r *repoDrones) PopulateDB() error {
 	log.Println("successfully added medications")
 
 	// set IsPopulated to true
-	err = db.Update(func(tx *buntdb.Tx) error {
+	err = r.db.Update(func(tx *buntdb.Tx) error {
 		res, err := jsoniter.MarshalToString(dto.ConfigDB{IsPopulated: true})
 		if err != nil {
 			return err
@@ -153,356 +209,1589 @@ func (r *repoDrones) PopulateDB() error {
 	return nil
 }
 
-// GetUser get the user from the DB
-func (r *repoDrones) GetUser(field string, filterOptional ...bool) (*dto.User, error) {
-	filter := false
-	if len(filterOptional) > 0 {
-		filter = filterOptional[0]
+// region ======== Users ======================================================
+
+// GetUser get a user by username
+func (r *repoDrones) GetUser(username string) (*dto.User, error) {
+	var user *dto.User
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		user, err = getUserTx(tx, username)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	user := dto.User{}
 
-	// Open the data.db file. It will be created if it doesn't exist.
-	db, err := buntdb.Open(r.DBUserLocation)
+	return user, nil
+}
+
+// GetUsers return a list of dto.User sorted by username
+func (r *repoDrones) GetUsers() (*[]dto.User, error) {
+	list := make([]dto.User, 0)
+
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var errUnmarshal error
+		err := tx.Ascend("username", func(key, value string) bool {
+			user := dto.User{}
+			if errUnmarshal = jsoniter.UnmarshalFromString(value, &user); errUnmarshal != nil {
+				return false
+			}
+			list = append(list, user)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return errUnmarshal
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// CreateUser adds a new user, the username must be unique
+func (r *repoDrones) CreateUser(user *dto.User) error {
+	log.Printf("creating the user '%s'", user.Username)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get("user:" + user.Username); err == nil {
+			return schema.ErrUserExists
+		} else if err != buntdb.ErrNotFound {
+			return err
+		}
+		return setUserTx(tx, user)
+	})
+}
+
+// UpdateUser updates a user, the current user is read, changed by update and written in the same
+// transaction. The username can not change, and the change can not leave the users API without an
+// enabled admin
+func (r *repoDrones) UpdateUser(username string, update func(user *dto.User) error) (*dto.User, error) {
+	log.Printf("updating the user '%s'", username)
+	var user *dto.User
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		var err error
+		if user, err = getUserTx(tx, username); err != nil {
+			return err
+		}
+		wasAdmin := isEnabledAdmin(user)
+		if err = update(user); err != nil {
+			return err
+		}
+		user.Username = username
+		if wasAdmin && !isEnabledAdmin(user) {
+			admins, err := countEnabledAdminsTx(tx)
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return schema.ErrLastAdmin
+			}
+		}
+		return setUserTx(tx, user)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
 
-	err = db.CreateIndex("username", "*", buntdb.IndexString)
+	return user, nil
+}
+
+// endregion ======== Users ======================================================
+
+// region ======== Refresh tokens ======================================================
+
+// CreateRefreshToken stores a refresh token, buntdb removes it when the ttl expires
+func (r *repoDrones) CreateRefreshToken(token *dto.RefreshToken, ttl time.Duration) error {
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		return setRefreshTokenTx(tx, token, ttl)
+	})
+}
+
+// RotateRefreshToken replaces a refresh token with the one returned by rotate, in the same transaction. The used
+// token is kept until it expires, so using it again is detected: every token of its family is revoked and
+// schema.ErrRefreshTokenReused is returned. It returns the used token
+func (r *repoDrones) RotateRefreshToken(id string, ttl time.Duration, rotate func(current *dto.RefreshToken) (*dto.RefreshToken, error)) (*dto.RefreshToken, error) {
+	var current *dto.RefreshToken
+	reused := false
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		var err error
+		if current, err = getRefreshTokenTx(tx, id); err != nil {
+			return err
+		}
+		if current.ReplacedBy != "" {
+			// the revocation must be committed, the error is returned after the transaction
+			reused = true
+			return revokeRefreshTokensTx(tx, current.Family)
+		}
+
+		next, err := rotate(current)
+		if err != nil {
+			return err
+		}
+		next.Family = current.Family
+		if err = setRefreshTokenTx(tx, next, ttl); err != nil {
+			return err
+		}
+
+		remaining, err := tx.TTL("refresh:" + id)
+		if err != nil {
+			return err
+		}
+		current.ReplacedBy = next.ID
+		return setRefreshTokenTx(tx, current, remaining)
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = db.View(func(tx *buntdb.Tx) error {
-		if filter {
-			err := tx.Ascend("username", func(key, value string) bool {
-				if strings.Contains(value, field) {
-					err := jsoniter.UnmarshalFromString(value, &user)
-					if err != nil {
-						return false
-					}
-					return false
-				}
+	if reused {
+		log.Printf("revoked the refresh tokens of the family %s, a used token was replayed", current.Family)
+		return nil, schema.ErrRefreshTokenReused
+	}
 
-				return true
-			})
-			return err
-		}
-		// filter = false
-		//value, err := tx.Get(field)
-		//if err != nil {
-		//	return err
-		//}
-		//err = jsoniter.UnmarshalFromString(value, &user)
-		//if err != nil {
-		//	return err
-		//}
+	return current, nil
+}
+
+// RevokeRefreshTokens removes every refresh token of a family
+func (r *repoDrones) RevokeRefreshTokens(family string) error {
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		return revokeRefreshTokensTx(tx, family)
+	})
+}
+
+// endregion ======== Refresh tokens ======================================================
+
+// region ======== Drones ======================================================
+
+// GetDrone get a specific drone
+func (r *repoDrones) GetDrone(serialNumber string) (*dto.Drone, error) {
+	drone := dto.Drone{}
+
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		value, err := tx.Get("drone:" + serialNumber)
+		if err != nil {
+			return err
+		}
+		err = jsoniter.UnmarshalFromString(value, &drone)
+		if err != nil {
+			return err
+		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return &drone, nil
 }
 
-// GetUsers return a list of dto.User
-func (r *repoDrones) GetUsers() (*[]dto.User, error) {
-	// Open the data.db file. It will be created if it doesn't exist.
-	db, err := buntdb.Open(r.DBUserLocation)
+// GetDrones A read-only transaction, return a page of drones filtered by the decoded drone values.
+// The drones are sorted with the index of the sort field, the ties are sorted by serial number.
+// The decommissioned drones are returned only if it is requested
+func (r *repoDrones) GetDrones(filter *dto.DroneFilter) (*dto.DronesPage, error) {
+	var after *droneCursor
+	if filter.Cursor != "" {
+		var err error
+		if after, err = decodeDroneCursor(filter.Cursor); err != nil {
+			return nil, schema.ErrInvalidCursor
+		}
+	}
+
+	page := dto.DronesPage{Drones: make([]dto.Drone, 0)}
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		iterator := func(key, value string) bool {
+			drone := dto.Drone{}
+			err = jsoniter.UnmarshalFromString(value, &drone)
+			if err != nil {
+				return false
+			}
+			if !matchDroneFilter(&drone, filter) || (after != nil && !after.precedes(&drone, filter)) {
+				return true
+			}
+			if filter.Limit > 0 && len(page.Drones) == filter.Limit {
+				// there are more drones, the next page starts after the last drone of this page
+				page.NextCursor, err = encodeDroneCursor(&page.Drones[len(page.Drones)-1], filter.Sort)
+				return false
+			}
+			page.Drones = append(page.Drones, drone)
+			return true
+		}
+
+		var errIter error
+		switch {
+		case filter.Sort == dto.DroneSortSerial && filter.Desc:
+			errIter = tx.DescendKeys("drone:*", iterator)
+		case filter.Sort == dto.DroneSortSerial:
+			errIter = tx.AscendKeys("drone:*", iterator)
+		case filter.Sort == dto.DroneSortModel && filter.Desc:
+			errIter = tx.Descend("drone_model", iterator)
+		case filter.Sort == dto.DroneSortModel:
+			errIter = tx.Ascend("drone_model", iterator)
+		case filter.Desc:
+			errIter = tx.Descend("drone_state", iterator)
+		default:
+			errIter = tx.Ascend("drone_state", iterator)
+		}
+		if errIter != nil {
+			return errIter
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// GetLoadableDrones A read-only transaction, return the drones that can be loaded with a payload, the payload
+// weight is payloadWeight plus the weight of the medication items. canLoad checks every drone (state, battery)
+// and the payload can not exceed its weight limit. Returns ErrMedicationItemNotFound if a medication item does not exist
+func (r *repoDrones) GetLoadableDrones(medicationItems []dto.MedicationItem, payloadWeight float64, canLoad func(drone *dto.Drone) error) (*[]dto.AvailableDrone, error) {
+	var dronesList []dto.AvailableDrone
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		if len(medicationItems) > 0 {
+			medicationIdsRealMap, err := getMedicationWeightsTx(tx)
+			if err != nil {
+				return err
+			}
+			itemsWeight, allIDValid := thereAreAll(medicationIdsRealMap, medicationItems)
+			if !allIDValid {
+				return schema.ErrMedicationItemNotFound
+			}
+			payloadWeight += itemsWeight
+		}
+
+		var err error
+		dronesList, err = getLoadableDronesTx(tx, payloadWeight, canLoad)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dronesList, nil
+}
+
+// RegisterDrone writes a new drone, returns ErrDroneExists if the serial number is in use
+// (a decommissioned drone keeps its serial number). The fleet size is checked in the same transaction,
+// returns ErrDroneFleetFull if there are already maxDrones active drones (zero or less disables it)
+func (r *repoDrones) RegisterDrone(drone *dto.Drone, maxDrones int) error {
+	log.Printf("writing the drone '%s' in database", drone.SerialNumber)
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Get("drone:" + drone.SerialNumber)
+		if err == nil {
+			return schema.ErrDroneExists
+		} else if err != buntdb.ErrNotFound {
+			return err
+		}
+		if maxDrones > 0 {
+			fleetSize, err := countActiveDronesTx(tx)
+			if err != nil {
+				return err
+			}
+			if fleetSize >= maxDrones {
+				return schema.ErrDroneFleetFull
+			}
+		}
+		return r.setDroneTx(tx, drone)
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("successfully added drone")
+	return nil
+}
+
+// UpdateDrone updates a drone, the current drone is read and the one returned by update is written in the
+// same transaction. The serial number can not change, an IDLE drone does not carry medication items
+// and the loaded medication items can not exceed the weight limit
+func (r *repoDrones) UpdateDrone(serialNumber string, update func(drone *dto.Drone, loaded []dto.MedicationItem) (*dto.Drone, error)) (*dto.Drone, error) {
+	log.Printf("updating the drone '%s' in database", serialNumber)
+	var drone *dto.Drone
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		current, err := getDroneTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		loadedMeds, err := getLoadedMedicationsTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		drone, err = update(current, loadedMeds)
+		if err != nil {
+			return err
+		}
+		drone.SerialNumber = serialNumber
+
+		if drone.State == dto.IDLE {
+			if err = deleteLoadedMedicationsTx(tx, serialNumber); err != nil {
+				return err
+			}
+		} else {
+			medicationIdsRealMap, err := getMedicationWeightsTx(tx)
+			if err != nil {
+				return err
+			}
+			if packedTotalWeight, _ := thereAreAll(medicationIdsRealMap, loadedMeds); packedTotalWeight > drone.WeightLimit {
+				return schema.ErrDroneMaximumLoadWeightExceeded
+			}
+		}
+		return r.setDroneTx(tx, drone)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return drone, nil
+}
+
+// DecommissionDrone removes a drone from the fleet. The drone is kept as a tombstone with the decommission
+// date, so the event log can still be interpreted. canDecommission checks the drone, and a drone that
+// carries medication items can not be decommissioned
+func (r *repoDrones) DecommissionDrone(serialNumber string, decommissioned string, canDecommission func(drone *dto.Drone) error) error {
+	log.Printf("decommissioning the drone '%s'", serialNumber)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		drone, err := getDroneTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		if err = canDecommission(drone); err != nil {
+			return err
+		}
+		loadedMeds, err := getLoadedMedicationsTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		if len(loadedMeds) > 0 {
+			return schema.ErrDroneLoaded
+		}
+		drone.Decommissioned = decommissioned
+		return r.setDroneTx(tx, drone)
+	})
+}
+
+// UpdateDroneState moves the drone to a new state. The current state is read and the new one is
+// written in the same transaction, canMove decides if the move is allowed, it gets the medication
+// items loaded by the drone
+func (r *repoDrones) UpdateDroneState(serialNumber string, state dto.DroneState, canMove func(drone *dto.Drone, state dto.DroneState, loaded []dto.MedicationItem) error) (*dto.Drone, error) {
+	var drone *dto.Drone
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		var err error
+		drone, err = getDroneTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		loaded, err := getLoadedMedicationsTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		if err = canMove(drone, state, loaded); err != nil {
+			return err
+		}
+		log.Printf("moving the drone '%s' from %s to %s", serialNumber, drone.State, state)
+		drone.State = state
+		// an IDLE drone does not carry medication items
+		if state == dto.IDLE {
+			if err = deleteLoadedMedicationsTx(tx, serialNumber); err != nil {
+				return err
+			}
+		}
+		return r.setDroneTx(tx, drone)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return drone, nil
+}
+
+// ApplyTelemetry applies the telemetry reported for many drones in one transaction. apply checks and
+// updates each drone, it gets the medication items loaded by the drone. The error of an entry is returned
+// at the same position and it does not prevent the other entries from being applied
+func (r *repoDrones) ApplyTelemetry(telemetry []dto.RequestTelemetry, apply func(drone *dto.Drone, loaded []dto.MedicationItem, telemetry *dto.RequestTelemetry) error) ([]error, error) {
+	log.Printf("applying the telemetry of %d drones", len(telemetry))
+	var errs []error
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		errs = make([]error, len(telemetry))
+		for i := range telemetry {
+			drone, err := getDroneTx(tx, telemetry[i].SerialNumber)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			loaded, err := getLoadedMedicationsTx(tx, drone.SerialNumber)
+			if err != nil {
+				return err
+			}
+			if err = apply(drone, loaded, &telemetry[i]); err != nil {
+				errs[i] = err
+				continue
+			}
+			// an IDLE drone does not carry medication items
+			if drone.State == dto.IDLE {
+				if err = deleteLoadedMedicationsTx(tx, drone.SerialNumber); err != nil {
+					return err
+				}
+			}
+			if err = r.setDroneTx(tx, drone); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
 
-	user := dto.User{}
-	var list []dto.User
+	return errs, nil
+}
+
+// CheckingLoadedMedicationsItems checking loaded medication items for a given drone,
+// returns the items with their quantities and the total payload weight
+func (r *repoDrones) CheckingLoadedMedicationsItems(serialNumber string) (*dto.LoadedMedications, error) {
+	loadedMeds := dto.LoadedMedications{}
+
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		loadedMeds.Items, err = getLoadedMedicationsTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		medicationIdsRealMap, err := getMedicationWeightsTx(tx)
+		if err != nil {
+			return err
+		}
+		for _, item := range loadedMeds.Items {
+			loadedMeds.TotalWeight += medicationIdsRealMap[item.Code] * float64(item.Quantity)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &loadedMeds, nil
+}
+
+// LoadMedicationItemsADrone loads a drone with medication items. The whole check-and-load runs in a
+// single write transaction: canLoad checks the drone (state, battery), then the medication items and
+// their weight are validated, the items are written and the drone moves to LOADED state.
+// If appendItems is true the new items are added to the already loaded ones and the total weight is checked
+func (r *repoDrones) LoadMedicationItemsADrone(serialNumber string, medicationItems []dto.MedicationItem, appendItems bool, canLoad func(drone *dto.Drone) error) error {
+	log.Printf("loading a drone '%s' with medication items: %v", serialNumber, medicationItems)
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		drone, err := getDroneTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		if err = canLoad(drone); err != nil {
+			return err
+		}
+
+		if appendItems {
+			loadedMeds, err := getLoadedMedicationsTx(tx, serialNumber)
+			if err != nil {
+				return err
+			}
+			medicationItems = append(loadedMeds, medicationItems...)
+		}
+		if medicationItems, err = loadDroneTx(tx, drone, medicationItems); err != nil {
+			return err
+		}
+		// the delivery goes through an order, a drone loaded without order gets one with no destination
+		if drone.State == dto.IDLE {
+			_, err = newOrderTx(tx, serialNumber, "", medicationItems)
+		} else {
+			err = setActiveOrderItemsTx(tx, serialNumber, medicationItems)
+		}
+		if err != nil {
+			return err
+		}
+
+		// IDLE → LOADING → LOADED, the LOADING state is never visible outside this transaction
+		drone.State = dto.LOADED
+		return r.setDroneTx(tx, drone)
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("successfully loaded medication items")
+
+	return nil
+}
+
+// RemoveMedicationItemADrone removes a medication item (every unit) from the drone load, canUnload checks the drone.
+// When the last item is removed the drone moves back to IDLE state
+func (r *repoDrones) RemoveMedicationItemADrone(serialNumber string, code string, canUnload func(drone *dto.Drone) error) error {
+	log.Printf("removing the medication item '%s' from the drone '%s'", code, serialNumber)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		drone, err := getDroneTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		if err = canUnload(drone); err != nil {
+			return err
+		}
+
+		loadedMeds, err := getLoadedMedicationsTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		remaining := make([]dto.MedicationItem, 0, len(loadedMeds))
+		for _, loaded := range loadedMeds {
+			if loaded.Code != code {
+				remaining = append(remaining, loaded)
+			}
+		}
+		if len(remaining) == len(loadedMeds) {
+			return schema.ErrMedicationItemNotFound
+		}
+
+		if len(remaining) == 0 {
+			drone.State = dto.IDLE
+			if err = deleteLoadedMedicationsTx(tx, serialNumber); err != nil {
+				return err
+			}
+			return r.setDroneTx(tx, drone)
+		}
+		res, err := jsoniter.MarshalToString(remaining)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set("loaded_medications:"+serialNumber, res, nil)
+		if err != nil {
+			return err
+		}
+		return setActiveOrderItemsTx(tx, serialNumber, remaining)
+	})
+}
+
+// UnloadMedicationItemsADrone removes every medication item from the drone load, canUnload checks the drone.
+// The drone moves back to IDLE state
+func (r *repoDrones) UnloadMedicationItemsADrone(serialNumber string, canUnload func(drone *dto.Drone) error) error {
+	log.Printf("unloading the drone '%s'", serialNumber)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		drone, err := getDroneTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		if err = canUnload(drone); err != nil {
+			return err
+		}
+		if err = deleteLoadedMedicationsTx(tx, serialNumber); err != nil {
+			return err
+		}
+		drone.State = dto.IDLE
+		return r.setDroneTx(tx, drone)
+	})
+}
+
+func (r *repoDrones) ExistDrone(serialNumber string) error {
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get("drone:" + serialNumber)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	// Getting non-existent values will cause an ErrNotFound error.
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// endregion ======== Drones ======================================================
+
+// region ======== Medications ======================================================
+
+func (r *repoDrones) GetMedications() (*[]dto.Medication, error) {
+	medication := dto.Medication{}
+	medicationsList := make([]dto.Medication, 0)
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		errIter := tx.Descend("medication_state", func(key, value string) bool {
+			err = jsoniter.UnmarshalFromString(value, &medication)
+			if err == nil {
+				medicationsList = append(medicationsList, medication)
+			}
+			return err == nil
+		})
+		if errIter != nil {
+			return errIter
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &medicationsList, nil
+}
+
+// AddMedication adds a new medication to the catalogue, returns ErrMedicationExists if the code is in use
+func (r *repoDrones) AddMedication(medication *dto.Medication) error {
+	log.Printf("writing the medication '%s' in database", medication.Code)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Get("med:" + medication.Code)
+		if err == nil {
+			return schema.ErrMedicationExists
+		} else if err != buntdb.ErrNotFound {
+			return err
+		}
+		return setMedicationTx(tx, medication)
+	})
+}
+
+// UpdateMedication replaces an existing medication, returns buntdb.ErrNotFound if it does not exist
+func (r *repoDrones) UpdateMedication(medication *dto.Medication) error {
+	log.Printf("updating the medication '%s' in database", medication.Code)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get("med:" + medication.Code); err != nil {
+			return err
+		}
+		return setMedicationTx(tx, medication)
+	})
+}
+
+// DeleteMedication removes a medication from the catalogue. The check and the removal run in the
+// same transaction, returns ErrMedicationLoaded if any drone is carrying the medication
+func (r *repoDrones) DeleteMedication(code string) error {
+	log.Printf("removing the medication '%s' from database", code)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get("med:" + code); err != nil {
+			return err
+		}
+		loaded, err := isMedicationLoadedTx(tx, code)
+		if err != nil {
+			return err
+		}
+		if loaded {
+			return schema.ErrMedicationLoaded
+		}
+		_, err = tx.Delete("med:" + code)
+		return err
+	})
+}
+
+// endregion ======== Medications ======================================================
+
+// region ======== Orders ======================================================
+
+// GetOrders get the orders sorted descending by creation date, optionally filtered by drone and status
+func (r *repoDrones) GetOrders(filter *dto.OrderFilter) (*[]dto.Order, error) {
+	var ordersList []dto.Order
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var errUnmarshal error
+		err := tx.Descend("order_created", func(key, value string) bool {
+			order := dto.Order{}
+			if errUnmarshal = jsoniter.UnmarshalFromString(value, &order); errUnmarshal != nil {
+				return false
+			}
+			if filter.SerialNumber != "" && order.SerialNumber != filter.SerialNumber {
+				return true
+			}
+			if filter.Status != nil && order.Status != *filter.Status {
+				return true
+			}
+			ordersList = append(ordersList, order)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return errUnmarshal
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ordersList == nil {
+		ordersList = []dto.Order{}
+	}
+
+	return &ordersList, nil
+}
+
+func (r *repoDrones) GetOrder(id string) (*dto.Order, error) {
+	var order *dto.Order
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		order, err = getOrderTx(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// CreateOrder creates an order and loads the drone with its medication items in the same transaction,
+// canLoad checks the drone
+func (r *repoDrones) CreateOrder(serialNumber string, destination string, medicationItems []dto.MedicationItem, canLoad func(drone *dto.Drone) error) (*dto.Order, error) {
+	log.Printf("creating an order for the drone '%s' to '%s'", serialNumber, destination)
+	var order *dto.Order
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		drone, err := getDroneTx(tx, serialNumber)
+		if err != nil {
+			return err
+		}
+		if err = canLoad(drone); err != nil {
+			return err
+		}
+		if medicationItems, err = loadDroneTx(tx, drone, medicationItems); err != nil {
+			return err
+		}
+		if order, err = newOrderTx(tx, serialNumber, destination, medicationItems); err != nil {
+			return err
+		}
+
+		drone.State = dto.LOADED
+		return r.setDroneTx(tx, drone)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// CancelOrder cancels an order that was not delivered yet, the drone is unloaded and it moves back to IDLE
+// state. canUnload checks the drone
+func (r *repoDrones) CancelOrder(id string, canUnload func(drone *dto.Drone) error) error {
+	log.Printf("cancelling the order '%s'", id)
+	return r.db.Update(func(tx *buntdb.Tx) error {
+		order, err := getOrderTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if order.Status == dto.OrderDelivered || order.Status == dto.OrderCancelled {
+			return schema.ErrOrderClosed
+		}
+		drone, err := getDroneTx(tx, order.SerialNumber)
+		if err != nil {
+			return err
+		}
+		if err = canUnload(drone); err != nil {
+			return err
+		}
+		if err = deleteLoadedMedicationsTx(tx, drone.SerialNumber); err != nil {
+			return err
+		}
+		// the order is cancelled when the drone moves back to IDLE
+		drone.State = dto.IDLE
+		return r.setDroneTx(tx, drone)
+	})
+}
+
+// PlanDispatch splits the medication items across the drones that can be loaded, plan packs the items over the candidates.
+// When commit is true every drone of the plan is loaded through its own order in the same transaction, otherwise the
+// plan is only a proposal. Returns ErrNoDroneAvailable if no drone can start loading
+func (r *repoDrones) PlanDispatch(destination string, medicationItems []dto.MedicationItem, commit bool, canLoad func(drone *dto.Drone) error, plan func(candidates []dto.AvailableDrone, weights map[string]float64, items []dto.MedicationItem) ([]dto.PlannedLoad, error)) (*dto.DispatchPlan, error) {
+	log.Printf("planning the dispatch of the medication items %v to '%s' (commit: %t)", medicationItems, destination, commit)
+	dispatchPlan := dto.DispatchPlan{Committed: commit}
+	planTx := func(tx *buntdb.Tx) error {
+		medicationItems = mergeMedicationItems(medicationItems)
+		medicationIdsRealMap, err := getMedicationWeightsTx(tx)
+		if err != nil {
+			return err
+		}
+		payloadWeight, allIDValid := thereAreAll(medicationIdsRealMap, medicationItems)
+		if !allIDValid {
+			return schema.ErrMedicationItemNotFound
+		}
+		dispatchPlan.PayloadWeight = payloadWeight
+
+		candidates, err := getLoadableDronesTx(tx, 0, canLoad)
+		if err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			return schema.ErrNoDroneAvailable
+		}
+		if dispatchPlan.Loads, err = plan(candidates, medicationIdsRealMap, medicationItems); err != nil {
+			return err
+		}
+		if !commit {
+			return nil
+		}
+
+		for i := range dispatchPlan.Loads {
+			load := &dispatchPlan.Loads[i]
+			drone, err := getDroneTx(tx, load.SerialNumber)
+			if err != nil {
+				return err
+			}
+			if load.Items, err = loadDroneTx(tx, drone, load.Items); err != nil {
+				return err
+			}
+			if load.Order, err = newOrderTx(tx, drone.SerialNumber, destination, load.Items); err != nil {
+				return err
+			}
+			drone.State = dto.LOADED
+			if err = r.setDroneTx(tx, drone); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if commit {
+		err = r.db.Update(planTx)
+	} else {
+		err = r.db.View(planTx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &dispatchPlan, nil
+}
+
+// DispatchOrder picks a drone for the medication items and creates the order loading it, in the same transaction.
+// canLoad checks every drone and pick chooses among the drones that can carry the payload. Returns ErrNoDroneAvailable
+// if no drone can start loading, or ErrDroneMaximumLoadWeightExceeded if the payload is too heavy for all of them
+func (r *repoDrones) DispatchOrder(destination string, medicationItems []dto.MedicationItem, canLoad func(drone *dto.Drone) error, pick func(candidates []dto.AvailableDrone) *dto.AvailableDrone) (*dto.Drone, *dto.Order, error) {
+	log.Printf("dispatching the medication items %v to '%s'", medicationItems, destination)
+	var drone *dto.Drone
+	var order *dto.Order
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		medicationIdsRealMap, err := getMedicationWeightsTx(tx)
+		if err != nil {
+			return err
+		}
+		payloadWeight, allIDValid := thereAreAll(medicationIdsRealMap, medicationItems)
+		if !allIDValid {
+			return schema.ErrMedicationItemNotFound
+		}
+
+		ready, err := getLoadableDronesTx(tx, 0, canLoad)
+		if err != nil {
+			return err
+		}
+		if len(ready) == 0 {
+			return schema.ErrNoDroneAvailable
+		}
+		candidates := make([]dto.AvailableDrone, 0, len(ready))
+		for _, available := range ready {
+			if payloadWeight <= available.WeightLimit {
+				available.RemainingCapacity = available.WeightLimit - payloadWeight
+				candidates = append(candidates, available)
+			}
+		}
+		if len(candidates) == 0 {
+			return fmt.Errorf("%w: the payload weighs %.2fgr, more than the weight limit of every available drone", schema.ErrDroneMaximumLoadWeightExceeded, payloadWeight)
+		}
+
+		chosen := pick(candidates).Drone
+		drone = &chosen
+		if medicationItems, err = loadDroneTx(tx, drone, medicationItems); err != nil {
+			return err
+		}
+		if order, err = newOrderTx(tx, drone.SerialNumber, destination, medicationItems); err != nil {
+			return err
+		}
+
+		drone.State = dto.LOADED
+		return r.setDroneTx(tx, drone)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return drone, order, nil
+}
+
+// endregion ======== Orders ======================================================
+
+// region ======== Alerts ======================================================
+
+// GetAlerts get the alerts sorted descending by creation date, optionally filtered by drone and status
+func (r *repoDrones) GetAlerts(filter *dto.AlertFilter) (*[]dto.Alert, error) {
+	var alertsList []dto.Alert
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var errUnmarshal error
+		err := tx.Descend("alert_created", func(key, value string) bool {
+			alert := dto.Alert{}
+			if errUnmarshal = jsoniter.UnmarshalFromString(value, &alert); errUnmarshal != nil {
+				return false
+			}
+			if filter.SerialNumber != "" && alert.SerialNumber != filter.SerialNumber {
+				return true
+			}
+			if filter.Status != nil && alert.Status != *filter.Status {
+				return true
+			}
+			alertsList = append(alertsList, alert)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return errUnmarshal
+	})
+	if err != nil {
+		return nil, err
+	}
+	if alertsList == nil {
+		alertsList = []dto.Alert{}
+	}
+
+	return &alertsList, nil
+}
+
+// UpdateAlert updates an alert, the current alert is read, changed by update and written in the same transaction
+func (r *repoDrones) UpdateAlert(id string, update func(alert *dto.Alert) error) (*dto.Alert, error) {
+	var alert *dto.Alert
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		var err error
+		if alert, err = getAlertTx(tx, id); err != nil {
+			return err
+		}
+		if err = update(alert); err != nil {
+			return err
+		}
+		alert.ID = id
+		return setAlertTx(tx, alert)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return alert, nil
+}
+
+// endregion ======== Alerts ======================================================
+
+// region ======== PRIVATE AUX ===========================================================
+
+// getUserTx get a user inside a transaction
+func getUserTx(tx *buntdb.Tx, username string) (*dto.User, error) {
+	value, err := tx.Get("user:" + username)
+	if err != nil {
+		return nil, err
+	}
+	user := dto.User{}
+	if err = jsoniter.UnmarshalFromString(value, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// isEnabledAdmin reports whether the user can manage the users
+func isEnabledAdmin(user *dto.User) bool {
+	return user.Role == dto.RoleAdmin && !user.Disabled
+}
+
+// countEnabledAdminsTx counts the users that can manage the users
+func countEnabledAdminsTx(tx *buntdb.Tx) (int, error) {
+	admins := 0
+	var errUnmarshal error
+	err := tx.Ascend("username", func(key, value string) bool {
+		user := dto.User{}
+		if errUnmarshal = jsoniter.UnmarshalFromString(value, &user); errUnmarshal != nil {
+			return false
+		}
+		if isEnabledAdmin(&user) {
+			admins++
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return admins, errUnmarshal
+}
+
+// setUserTx write a user inside a transaction
+func setUserTx(tx *buntdb.Tx, user *dto.User) error {
+	res, err := jsoniter.MarshalToString(user)
+	if err != nil {
+		return err
+	}
+	_, _, err = tx.Set("user:"+user.Username, res, nil)
+	return err
+}
+
+// getRefreshTokenTx get a refresh token inside a transaction
+func getRefreshTokenTx(tx *buntdb.Tx, id string) (*dto.RefreshToken, error) {
+	value, err := tx.Get("refresh:" + id)
+	if err != nil {
+		return nil, err
+	}
+	token := dto.RefreshToken{}
+	if err = jsoniter.UnmarshalFromString(value, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// setRefreshTokenTx write a refresh token inside a transaction, it expires after the ttl
+func setRefreshTokenTx(tx *buntdb.Tx, token *dto.RefreshToken, ttl time.Duration) error {
+	res, err := jsoniter.MarshalToString(token)
+	if err != nil {
+		return err
+	}
+	_, _, err = tx.Set("refresh:"+token.ID, res, &buntdb.SetOptions{Expires: true, TTL: ttl})
+	return err
+}
+
+// revokeRefreshTokensTx removes every refresh token of a family inside a transaction
+func revokeRefreshTokensTx(tx *buntdb.Tx, family string) error {
+	var keys []string
+	var errUnmarshal error
+	err := tx.AscendKeys("refresh:*", func(key, value string) bool {
+		token := dto.RefreshToken{}
+		if errUnmarshal = jsoniter.UnmarshalFromString(value, &token); errUnmarshal != nil {
+			return false
+		}
+		if token.Family == family {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if errUnmarshal != nil {
+		return errUnmarshal
+	}
+	for _, key := range keys {
+		if _, err = tx.Delete(key); err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedUsers writes the initial users when the store has none, so the bootstrap admin can
+// authenticate before the database is populated
+func seedUsers(db *buntdb.DB) error {
+	var fakeUsersList = fakeUsers()
+
+	return db.Update(func(tx *buntdb.Tx) error {
+		seeded := false
+		err := tx.AscendKeys("user:*", func(key, value string) bool {
+			seeded = true
+			return false
+		})
+		if err != nil || seeded {
+			return err
+		}
 
-	err = db.CreateIndex("username", "*", buntdb.IndexString)
-	if err != nil {
-		return nil, err
-	}
-	err = db.View(func(tx *buntdb.Tx) error {
-		tx.Ascend("username", func(key, value string) bool {
-			err = jsoniter.UnmarshalFromString(value, &user)
-			if err == nil {
-				list = append(list, user)
+		log.Println("writing users in database")
+		for i := 0; i < len(fakeUsersList); i++ {
+			// the fake users hold their password, it is written as a hash
+			passphrase, err := lib.HashPassword(fakeUsersList[i].Passphrase)
+			if err != nil {
+				return err
 			}
-			return err == nil
-		})
+			fakeUsersList[i].Passphrase = passphrase
+			res, err := jsoniter.MarshalToString(fakeUsersList[i])
+			log.Printf("user #%d: %s", i, fakeUsersList[i].Username)
+			if err != nil {
+				return err
+			}
+			// add user value with "username" key
+			_, _, err = tx.Set("user:"+fakeUsersList[i].Username, res, nil)
+			if err != nil {
+				return err
+			}
+		}
+		log.Println("successfully added users")
 		return nil
 	})
-	if err != nil {
-		return nil, err
-	}
-
-	return &list, nil
 }
 
-// region ======== Drones ======================================================
-
-// GetDrone get a specific drone
-func (r *repoDrones) GetDrone(serialNumber string) (*dto.Drone, error) {
-	db, err := r.loadDB()
-	if err != nil {
-		return nil, err
+// migrateUsers upgrades the users written by older versions. The users under bare numeric keys are moved
+// to the "user:<username>" keys. The users without a role become viewers, except the bootstrap admin
+func migrateUsers(db *buntdb.DB, bootstrapAdmin string) error {
+	legacyRole := func(user *dto.User) string {
+		if user.Username == bootstrapAdmin {
+			return dto.RoleAdmin
+		}
+		return dto.RoleViewer
 	}
-	defer db.Close()
-
-	drone := dto.Drone{}
 
-	db.CreateIndex("drone_state", "drone:*", buntdb.IndexJSON("batteryCapacity"))
-	err = db.View(func(tx *buntdb.Tx) error {
-		value, err := tx.Get("drone:" + serialNumber)
+	return db.Update(func(tx *buntdb.Tx) error {
+		stored := make(map[string]string)
+		// the numeric keys sort between "0" and ":"
+		err := tx.AscendRange("", "0", ":", func(key, value string) bool {
+			if _, errAtoi := strconv.Atoi(key); errAtoi == nil {
+				stored[key] = value
+			}
+			return true
+		})
 		if err != nil {
 			return err
 		}
-		err = jsoniter.UnmarshalFromString(value, &drone)
+		err = tx.AscendKeys("user:*", func(key, value string) bool {
+			stored[key] = value
+			return true
+		})
 		if err != nil {
 			return err
 		}
+
+		for key, value := range stored {
+			user := dto.User{}
+			if err = jsoniter.UnmarshalFromString(value, &user); err != nil || user.Username == "" {
+				continue
+			}
+			if key == "user:"+user.Username {
+				if user.Role == "" {
+					user.Role = legacyRole(&user)
+					log.Printf("migrating the user '%s' to the %s role", user.Username, user.Role)
+					if err = setUserTx(tx, &user); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			log.Printf("migrating the user '%s' to the username key", user.Username)
+			if _, err = tx.Get("user:" + user.Username); err == buntdb.ErrNotFound {
+				if user.Role == "" {
+					user.Role = legacyRole(&user)
+				}
+				if err = setUserTx(tx, &user); err != nil {
+					return err
+				}
+			}
+			if _, err = tx.Delete(key); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-	if err != nil {
-		return nil, err
-	}
-
-	return &drone, nil
 }
 
-// GetDrones A read-only transaction, return drones in db
-// allows filtering by a specific string field
-func (r *repoDrones) GetDrones(filter string) (*[]dto.Drone, error) {
-	db, err := r.loadDB()
+// getDroneTx get a drone inside a transaction, a decommissioned drone can not be modified
+// and returns ErrDroneDecommissioned
+func getDroneTx(tx *buntdb.Tx, serialNumber string) (*dto.Drone, error) {
+	value, err := tx.Get("drone:" + serialNumber)
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
-
 	drone := dto.Drone{}
-	dronesList := make([]dto.Drone, 0)
-	// custom index: sort drones descending by battery capacity
-	db.CreateIndex("drone_state", "drone:*", buntdb.IndexJSON("batteryCapacity"))
-	err = db.View(func(tx *buntdb.Tx) error {
-		if filter != "" {
-			err := tx.Descend("drone_state", func(key, value string) bool {
-				if strings.Contains(value, filter) {
-					err = jsoniter.UnmarshalFromString(value, &drone)
-					if err == nil {
-						dronesList = append(dronesList, drone)
-					}
-					return err == nil
-				}
-				return true
-			})
-			return err
-		}
-		err := tx.Descend("drone_state", func(key, value string) bool {
-			err = jsoniter.UnmarshalFromString(value, &drone)
-			if err == nil {
-				dronesList = append(dronesList, drone)
-			}
-			return err == nil
-		})
-		return err
-	})
+	err = jsoniter.UnmarshalFromString(value, &drone)
 	if err != nil {
 		return nil, err
 	}
-
-	return &dronesList, nil
+	if drone.Decommissioned != "" {
+		return nil, schema.ErrDroneDecommissioned
+	}
+	return &drone, nil
 }
 
-func (r *repoDrones) RegisterDrone(drone *dto.Drone) error {
-	db, err := r.loadDB()
+// setDroneTx write a drone inside a transaction, the active order of the drone advances with its state
+// and an alert is created for every threshold its battery level drops below
+func (r *repoDrones) setDroneTx(tx *buntdb.Tx, drone *dto.Drone) error {
+	res, err := jsoniter.MarshalToString(drone)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-
-	log.Printf("writing the drone '%s' in database", drone.SerialNumber)
-	err = db.Update(func(tx *buntdb.Tx) error {
-		res, err := jsoniter.MarshalToString(drone)
-		if err != nil {
+	previousValue, replaced, err := tx.Set("drone:"+drone.SerialNumber, res, nil)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		previous := dto.Drone{}
+		if err = jsoniter.UnmarshalFromString(previousValue, &previous); err != nil {
 			return err
 		}
-		_, _, err = tx.Set("drone:"+drone.SerialNumber, res, nil)
-		if err != nil {
+		if err = r.newAlertsTx(tx, previous.BatteryCapacity, drone); err != nil {
 			return err
 		}
-		return nil
-	})
+	}
+	return advanceActiveOrderTx(tx, drone)
+}
+
+// matchDroneFilter checks the drone against the filters
+func matchDroneFilter(drone *dto.Drone, filter *dto.DroneFilter) bool {
+	switch {
+	case drone.Decommissioned != "" && !filter.IncludeDecommissioned:
+		return false
+	case filter.State != nil && drone.State != *filter.State:
+		return false
+	case filter.Model != nil && drone.Model != *filter.Model:
+		return false
+	case filter.MinBattery != nil && drone.BatteryCapacity < *filter.MinBattery:
+		return false
+	case filter.MaxBattery != nil && drone.BatteryCapacity > *filter.MaxBattery:
+		return false
+	}
+	return true
+}
+
+// droneCursor position of a drone in the drones list: the value of the sort field and the serial number
+type droneCursor struct {
+	Value        float64 `json:"v"`
+	SerialNumber string  `json:"s"`
+}
+
+// droneSortValue returns the value of the sort field, the serial number sort only uses the serial number
+func droneSortValue(drone *dto.Drone, sort string) float64 {
+	switch sort {
+	case dto.DroneSortSerial:
+		return 0
+	case dto.DroneSortModel:
+		return float64(drone.Model)
+	}
+	return drone.BatteryCapacity
+}
+
+// precedes reports whether the cursor comes before the drone in the sort order
+func (c *droneCursor) precedes(drone *dto.Drone, filter *dto.DroneFilter) bool {
+	value := droneSortValue(drone, filter.Sort)
+	if filter.Desc {
+		return value < c.Value || (value == c.Value && drone.SerialNumber < c.SerialNumber)
+	}
+	return value > c.Value || (value == c.Value && drone.SerialNumber > c.SerialNumber)
+}
+
+// encodeDroneCursor returns the opaque cursor of a drone
+func encodeDroneCursor(drone *dto.Drone, sort string) (string, error) {
+	res, err := json.Marshal(droneCursor{Value: droneSortValue(drone, sort), SerialNumber: drone.SerialNumber})
 	if err != nil {
-		return err
+		return "", err
 	}
-	log.Println("successfully added drone")
-	return nil
+	return base64.RawURLEncoding.EncodeToString(res), nil
 }
 
-// CheckingLoadedMedicationsItems checking loaded medication items for a given drone
-func (r *repoDrones) CheckingLoadedMedicationsItems(serialNumber string) (*[]string, error) {
-	db, err := r.loadDB()
+// decodeDroneCursor returns the drone position of an opaque cursor
+func decodeDroneCursor(cursor string) (*droneCursor, error) {
+	res, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
-
-	// medications id slice loaded by the drone
-	loadedMeds := make([]string, 0)
+	c := droneCursor{}
+	if err = json.Unmarshal(res, &c); err != nil {
+		return nil, err
+	}
+	if c.SerialNumber == "" {
+		return nil, schema.ErrInvalidCursor
+	}
+	return &c, nil
+}
 
-	db.CreateIndex("loaded_medications", "loaded_medications:*", buntdb.IndexString)
-	err = db.View(func(tx *buntdb.Tx) error {
-		value, err := tx.Get("loaded_medications:" + serialNumber)
+// countActiveDronesTx returns the number of drones in the fleet, the decommissioned ones are not counted
+func countActiveDronesTx(tx *buntdb.Tx) (int, error) {
+	count := 0
+	var err error
+	errIter := tx.Ascend("drone_state", func(key, value string) bool {
+		drone := dto.Drone{}
+		err = jsoniter.UnmarshalFromString(value, &drone)
 		if err != nil {
-			return err
+			return false
 		}
-		err = jsoniter.UnmarshalFromString(value, &loadedMeds)
+		if drone.Decommissioned == "" {
+			count++
+		}
+		return true
+	})
+	if errIter != nil {
+		return 0, errIter
+	}
+	return count, err
+}
+
+// getLoadableDronesTx returns the drones that can be loaded with a payload, canLoad checks every drone
+// (state, battery) and the payload can not exceed its weight limit. The drones are sorted descending by battery level
+func getLoadableDronesTx(tx *buntdb.Tx, payloadWeight float64, canLoad func(drone *dto.Drone) error) ([]dto.AvailableDrone, error) {
+	dronesList := make([]dto.AvailableDrone, 0)
+	var err error
+	errIter := tx.Descend("drone_state", func(key, value string) bool {
+		drone := dto.Drone{}
+		err = jsoniter.UnmarshalFromString(value, &drone)
 		if err != nil {
-			return err
+			return false
 		}
-		return nil
+		if drone.Decommissioned == "" && canLoad(&drone) == nil && payloadWeight <= drone.WeightLimit {
+			dronesList = append(dronesList, dto.AvailableDrone{Drone: drone, RemainingCapacity: drone.WeightLimit - payloadWeight})
+		}
+		return true
 	})
+	if errIter != nil {
+		return nil, errIter
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &loadedMeds, nil
+	return dronesList, nil
 }
 
-func (r *repoDrones) LoadMedicationItemsADrone(drone *dto.Drone, medicationItemIDs []interface{}) error {
-	db, err := r.loadDB()
-	if err != nil {
-		return err
-	}
-	defer db.Close()
+// loadDroneTx writes the medication items carried by a drone, a medication code appears once and its
+// quantities are added up. Returns the merged items
+func loadDroneTx(tx *buntdb.Tx, drone *dto.Drone, medicationItems []dto.MedicationItem) ([]dto.MedicationItem, error) {
+	medicationItems = mergeMedicationItems(medicationItems)
 
 	// begin: validating medication item IDs
-	medication := dto.Medication{}
-	medicationIdsRealMap := make(map[string]float64)
-
-	db.CreateIndex("medication_id", "med:*", buntdb.IndexJSON("weight"))
-	err = db.View(func(tx *buntdb.Tx) error {
-		err := tx.Descend("medication_id", func(key, value string) bool {
-			err = jsoniter.UnmarshalFromString(value, &medication)
-			if err == nil {
-				medicationIdsRealMap[medication.Code] = medication.Weight
-			}
-			return err == nil
-		})
-		return err
-	})
+	medicationIdsRealMap, err := getMedicationWeightsTx(tx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// to guarantee non-repeated id
-	medicationItemIDs = lib.Unique(medicationItemIDs)
-
-	// compares the request IDs (medicationItemIDs) with the collection obtained from the database (medicationIdsRealMap)
+	// compares the request items (medicationItems) with the collection obtained from the database (medicationIdsRealMap)
 	// also returns the total weight
-	packedTotalWeight, allIDValid := thereAreAll(medicationIdsRealMap, medicationItemIDs)
+	packedTotalWeight, allIDValid := thereAreAll(medicationIdsRealMap, medicationItems)
 	if !allIDValid {
-		return fmt.Errorf("at least one of the medication items does not exist")
+		return nil, schema.ErrMedicationItemNotFound
 	}
 
 	// prevent the drone from being loaded with more weight that it can carry
 	if packedTotalWeight > drone.WeightLimit {
-		return schema.ErrDroneMaximumLoadWeightExceeded
+		return nil, schema.ErrDroneMaximumLoadWeightExceeded
 	}
-
 	// end: validating medication item IDs
 
-	log.Printf("loading a drone '%s' with medication items: %s", drone.SerialNumber, medicationItemIDs)
-	err = db.Update(func(tx *buntdb.Tx) error {
-		res, err := jsoniter.MarshalToString(medicationItemIDs)
-		if err != nil {
-			return err
-		}
-		_, _, err = tx.Set("loaded_medications:"+drone.SerialNumber, res, nil)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	res, err := jsoniter.MarshalToString(medicationItems)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Println("successfully loaded medication items")
+	_, _, err = tx.Set("loaded_medications:"+drone.SerialNumber, res, nil)
+	if err != nil {
+		return nil, err
+	}
+	return medicationItems, nil
+}
 
-	return nil
+// getOrderTx get an order inside a transaction
+func getOrderTx(tx *buntdb.Tx, id string) (*dto.Order, error) {
+	value, err := tx.Get("order:" + id)
+	if err != nil {
+		return nil, err
+	}
+	order := dto.Order{}
+	if err = jsoniter.UnmarshalFromString(value, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
 }
 
-func (r *repoDrones) ExistDrone(serialNumber string) error {
-	db, err := r.loadDB()
+// setOrderTx write an order inside a transaction
+func setOrderTx(tx *buntdb.Tx, order *dto.Order) error {
+	res, err := jsoniter.MarshalToString(order)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	_, _, err = tx.Set("order:"+order.ID, res, nil)
+	return err
+}
 
-	err = db.View(func(tx *buntdb.Tx) error {
-		_, err := tx.Get("drone:" + serialNumber)
-		if err != nil {
+// newOrderTx creates a LOADED order and links it to the drone as its active order
+func newOrderTx(tx *buntdb.Tx, serialNumber string, destination string, medicationItems []dto.MedicationItem) (*dto.Order, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	order := dto.Order{
+		ID:           lib.GenerateUUIDStr(),
+		SerialNumber: serialNumber,
+		Destination:  destination,
+		Items:        medicationItems,
+		Status:       dto.OrderLoaded,
+		Created:      now,
+		Updated:      now,
+	}
+	if err := setOrderTx(tx, &order); err != nil {
+		return nil, err
+	}
+	if _, _, err := tx.Set("drone_order:"+serialNumber, order.ID, nil); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// getActiveOrderTx get the order being delivered by a drone, returns nil if the drone has no active order
+func getActiveOrderTx(tx *buntdb.Tx, serialNumber string) (*dto.Order, error) {
+	id, err := tx.Get("drone_order:" + serialNumber)
+	if err == buntdb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return getOrderTx(tx, id)
+}
+
+// setActiveOrderItemsTx updates the medication items of the active order of a drone
+func setActiveOrderItemsTx(tx *buntdb.Tx, serialNumber string, medicationItems []dto.MedicationItem) error {
+	order, err := getActiveOrderTx(tx, serialNumber)
+	if err != nil || order == nil {
+		return err
+	}
+	order.Items = medicationItems
+	order.Updated = time.Now().UTC().Format(time.RFC3339)
+	return setOrderTx(tx, order)
+}
+
+// advanceActiveOrderTx moves the active order of a drone to the status that matches the drone state. A delivered
+// order, or an order whose drone moved back without delivering it, is closed and it is no longer the active one
+func advanceActiveOrderTx(tx *buntdb.Tx, drone *dto.Drone) error {
+	order, err := getActiveOrderTx(tx, drone.SerialNumber)
+	if err != nil || order == nil {
+		return err
+	}
+	var status dto.OrderStatus
+	switch drone.State {
+	case dto.LOADING, dto.LOADED:
+		status = dto.OrderLoaded
+	case dto.DELIVERING:
+		status = dto.OrderDelivering
+	case dto.DELIVERED:
+		status = dto.OrderDelivered
+	default:
+		status = dto.OrderCancelled
+	}
+	if status == order.Status {
+		return nil
+	}
+	order.Status = status
+	order.Updated = time.Now().UTC().Format(time.RFC3339)
+	if status == dto.OrderDelivered || status == dto.OrderCancelled {
+		if _, err = tx.Delete("drone_order:" + drone.SerialNumber); err != nil {
 			return err
 		}
-		return nil
-	})
-	// Getting non-existent values will cause an ErrNotFound error.
-	if err != nil {
-		return err
 	}
+	return setOrderTx(tx, order)
+}
 
+// newAlertsTx creates an alert for every threshold crossed by the battery level of a drone, from a level
+// at or above the threshold to a level below it
+func (r *repoDrones) newAlertsTx(tx *buntdb.Tx, previousBattery float64, drone *dto.Drone) error {
+	for _, threshold := range r.alertThresholds {
+		if previousBattery < threshold || drone.BatteryCapacity >= threshold {
+			continue
+		}
+		alert := dto.Alert{
+			ID:              lib.GenerateUUIDStr(),
+			SerialNumber:    drone.SerialNumber,
+			Threshold:       threshold,
+			BatteryCapacity: drone.BatteryCapacity,
+			Created:         time.Now().UTC().Format(time.RFC3339),
+			Status:          dto.AlertRecorded,
+		}
+		if len(r.webhooks) > 0 {
+			alert.Status = dto.AlertPending
+			alert.Webhooks = append([]string(nil), r.webhooks...)
+		}
+		log.Printf("the battery level of the drone '%s' dropped below %g%%, alert %s", drone.SerialNumber, threshold, alert.ID)
+		if err := setAlertTx(tx, &alert); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// endregion ======== Drones ======================================================
-
-// region ======== Medications ======================================================
-
-func (r *repoDrones) GetMedications() (*[]dto.Medication, error) {
-	db, err := r.loadDB()
+// getAlertTx get an alert inside a transaction
+func getAlertTx(tx *buntdb.Tx, id string) (*dto.Alert, error) {
+	value, err := tx.Get("alert:" + id)
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
+	alert := dto.Alert{}
+	if err = jsoniter.UnmarshalFromString(value, &alert); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
 
-	medication := dto.Medication{}
-	medicationsList := make([]dto.Medication, 0)
-	// custom index: sort medications descending by weight
-	db.CreateIndex("medication_state", "med:*", buntdb.IndexJSON("weight"))
-	err = db.View(func(tx *buntdb.Tx) error {
-		err := tx.Descend("medication_state", func(key, value string) bool {
-			err = jsoniter.UnmarshalFromString(value, &medication)
-			if err == nil {
-				medicationsList = append(medicationsList, medication)
-			}
-			return err == nil
-		})
+// setAlertTx write an alert inside a transaction
+func setAlertTx(tx *buntdb.Tx, alert *dto.Alert) error {
+	res, err := jsoniter.MarshalToString(alert)
+	if err != nil {
 		return err
-	})
+	}
+	_, _, err = tx.Set("alert:"+alert.ID, res, nil)
+	return err
+}
+
+// getLoadedMedicationsTx returns the medication items loaded by a drone, an empty slice if it carries nothing.
+// The loads written as a list of codes are read as a single unit of each code
+func getLoadedMedicationsTx(tx *buntdb.Tx, serialNumber string) ([]dto.MedicationItem, error) {
+	loadedMeds := make([]dto.MedicationItem, 0)
+	value, err := tx.Get("loaded_medications:" + serialNumber)
+	if err == buntdb.ErrNotFound {
+		return loadedMeds, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = jsoniter.UnmarshalFromString(value, &loadedMeds)
 	if err != nil {
 		return nil, err
 	}
+	return loadedMeds, nil
+}
 
-	return &medicationsList, nil
+// deleteLoadedMedicationsTx removes the medication items loaded by a drone, if any
+func deleteLoadedMedicationsTx(tx *buntdb.Tx, serialNumber string) error {
+	_, err := tx.Delete("loaded_medications:" + serialNumber)
+	if err == buntdb.ErrNotFound {
+		return nil
+	}
+	return err
 }
 
-// endregion ======== Medications ======================================================
+// setMedicationTx write a medication inside a transaction
+func setMedicationTx(tx *buntdb.Tx, medication *dto.Medication) error {
+	res, err := jsoniter.MarshalToString(medication)
+	if err != nil {
+		return err
+	}
+	_, _, err = tx.Set("med:"+medication.Code, res, nil)
+	return err
+}
 
-// region ======== PRIVATE AUX ===========================================================
-func (r *repoDrones) loadDB() (*buntdb.DB, error) {
-	log.Println("Load DB ", r.DBUserLocation)
-	// Open the data.db file. It will be created if it doesn't exist.
-	db, err := buntdb.Open(r.DBUserLocation)
+// isMedicationLoadedTx checks if any drone is carrying the medication
+func isMedicationLoadedTx(tx *buntdb.Tx, code string) (bool, error) {
+	loaded := false
+	var err error
+	errIter := tx.Ascend("loaded_medications", func(key, value string) bool {
+		loadedMeds := make([]dto.MedicationItem, 0)
+		err = jsoniter.UnmarshalFromString(value, &loadedMeds)
+		if err != nil {
+			return false
+		}
+		for _, item := range loadedMeds {
+			if item.Code == code {
+				loaded = true
+				return false
+			}
+		}
+		return true
+	})
+	if errIter != nil {
+		return false, errIter
+	}
+	return loaded, err
+}
+
+// getMedicationWeightsTx returns the weight of every medication indexed by its code
+func getMedicationWeightsTx(tx *buntdb.Tx) (map[string]float64, error) {
+	medicationIdsRealMap := make(map[string]float64)
+	var err error
+	errIter := tx.Descend("medication_state", func(key, value string) bool {
+		medication := dto.Medication{}
+		err = jsoniter.UnmarshalFromString(value, &medication)
+		if err == nil {
+			medicationIdsRealMap[medication.Code] = medication.Weight
+		}
+		return err == nil
+	})
+	if errIter != nil {
+		return nil, errIter
+	}
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
-
-	return db, nil
+	return medicationIdsRealMap, nil
 }
 
 func isPopulated(db *buntdb.DB) bool {
 	log.Println("checking if StoreDB has already been populated")
 	configDB := dto.ConfigDB{}
-	db.CreateIndex("config", "config", buntdb.IndexString)
 	err := db.View(func(tx *buntdb.Tx) error {
 		value, err := tx.Get("config")
 		if err != nil {
@@ -519,7 +1808,8 @@ func isPopulated(db *buntdb.DB) bool {
 		log.Println("Database not found")
 		return false
 	} else if err != nil {
-		panic(err)
+		log.Println("Error checking if StoreDB has already been populated: ", err)
+		return false
 	}
 
 	return configDB.IsPopulated
@@ -527,13 +1817,15 @@ func isPopulated(db *buntdb.DB) bool {
 
 func fakeUsers() []dto.User {
 	var users = []dto.User{{
-		Passphrase: "0b14d501a594442a01c6859541bcb3e8164d183d32937b851835442f69d5c94e", // password1
+		Passphrase: "password1", // hashed by seedUsers
 		Username:   "richard.sargon@meinermail.com",
 		Name:       "Richard Sargon",
+		Role:       dto.RoleAdmin,
 	}, {
-		Passphrase: "6cf615d5bcaac778352a8f1f3360d23f02f34ec182e259897fd6ce485d7870d4", // password2
+		Passphrase: "password2", // hashed by seedUsers
 		Username:   "tom.carter@meinermail.com",
 		Name:       "Tom Carter",
+		Role:       dto.RoleDispatcher,
 	}}
 	return users
 }
@@ -644,20 +1936,36 @@ func fakeMedications() []dto.Medication {
 	return medications
 }
 
-// thereAreAll compares the request IDs (medicationItemIDs) with the collection
+// thereAreAll compares the request items (medicationItems) with the collection
 // obtained from the database (medicationIdsRealMap)
-// if they all exist then it also returns the total weight
-func thereAreAll(medicationIdsRealMap map[string]float64, medicationItemIDs []interface{}) (float64, bool) {
+// if they all exist then it also returns the total weight (weight × quantity of every item)
+func thereAreAll(medicationIdsRealMap map[string]float64, medicationItems []dto.MedicationItem) (float64, bool) {
 	var totalWeight = 0.0
-	for _, v := range medicationItemIDs {
-		_, exists := medicationIdsRealMap[v.(string)]
+	for _, v := range medicationItems {
+		weight, exists := medicationIdsRealMap[v.Code]
 		if !exists {
 			return 0.0, false
 		} else {
-			totalWeight += medicationIdsRealMap[v.(string)]
+			totalWeight += weight * float64(v.Quantity)
 		}
 	}
 	return totalWeight, true
 }
 
+// mergeMedicationItems returns one item per medication code with the quantities added up,
+// the codes keep the order of their first appearance
+func mergeMedicationItems(medicationItems []dto.MedicationItem) []dto.MedicationItem {
+	merged := make([]dto.MedicationItem, 0, len(medicationItems))
+	position := make(map[string]int, len(medicationItems))
+	for _, item := range medicationItems {
+		if i, exists := position[item.Code]; exists {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+		position[item.Code] = len(merged)
+		merged = append(merged, item)
+	}
+	return merged
+}
+
 // endregion =============================================================================
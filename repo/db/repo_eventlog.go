@@ -0,0 +1,176 @@
+package db
+
+import (
+	"log"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tidwall/buntdb"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+// region ======== SETUP =================================================================
+
+type RepoEventLog interface {
+	Close() error
+
+	AddLogEvent(logEvent *dto.LogEvent) error
+	GetLogEvents(filter *dto.LogEventFilter) (*[]dto.LogEvent, error)
+	PruneLogEvents(createdBefore string, maxEvents int) (int, error)
+}
+
+type repoEventLog struct {
+	DBLogLocation string
+	db            *buntdb.DB // long-lived handle, it is shared by every request during the process lifetime
+}
+
+// endregion =============================================================================
+
+// NewRepoEventLog open the event log database and create its indexes. The returned repository
+// owns the database handle until Close is called
+func NewRepoEventLog(svcConf *utils.SvcConfig) (RepoEventLog, error) {
+	log.Println("Load DB ", svcConf.LogDBPath)
+	// Open the event_log.db file. It will be created if it doesn't exist.
+	db, err := buntdb.Open(svcConf.LogDBPath)
+	if err != nil {
+		return nil, err
+	}
+	// custom index: sort log events by creation date, the date layout can be sorted as string
+	err = db.CreateIndex("log_created", "log:*", buntdb.IndexJSON("created"))
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &repoEventLog{DBLogLocation: svcConf.LogDBPath, db: db}, nil
+}
+
+// region ======== METHODS ===============================================================
+
+// Close closes the database handle, it must be called once on shutdown
+func (r *repoEventLog) Close() error {
+	log.Println("Close DB ", r.DBLogLocation)
+	return r.db.Close()
+}
+
+// AddLogEvent persists a new history/audit event in the event log database
+func (r *repoEventLog) AddLogEvent(logEvent *dto.LogEvent) error {
+	log.Printf("writing the log event '%s' in database", logEvent.UUID)
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		res, err := jsoniter.MarshalToString(logEvent)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set("log:"+logEvent.UUID, res, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("successfully added log event")
+	return nil
+}
+
+// GetLogEvents A read-only transaction, return the log events sorted descending by creation date.
+// The events are filtered by creation date range and drone, then paginated
+func (r *repoEventLog) GetLogEvents(filter *dto.LogEventFilter) (*[]dto.LogEvent, error) {
+	logEventsList := make([]dto.LogEvent, 0)
+	skipped := 0
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var errUnmarshal error
+		err := tx.Descend("log_created", func(key, value string) bool {
+			if filter.Limit > 0 && len(logEventsList) >= filter.Limit {
+				return false
+			}
+			logEvent := dto.LogEvent{}
+			errUnmarshal = jsoniter.UnmarshalFromString(value, &logEvent)
+			if errUnmarshal != nil {
+				return false
+			}
+			// descending order, so the newest events are skipped until "To" and the iteration stops at "From"
+			if filter.To != "" && logEvent.Created > filter.To {
+				return true
+			}
+			if filter.From != "" && logEvent.Created < filter.From {
+				return false
+			}
+			if filter.SerialNumber != "" {
+				logEvent.DronesBatteryLevels = filterBatteryLevels(logEvent.DronesBatteryLevels, filter.SerialNumber)
+				if len(logEvent.DronesBatteryLevels) == 0 {
+					return true
+				}
+			}
+			if skipped < filter.Offset {
+				skipped++
+				return true
+			}
+			logEventsList = append(logEventsList, logEvent)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return errUnmarshal
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logEventsList, nil
+}
+
+// PruneLogEvents apply the retention limits to the event log: removes the events created
+// before "createdBefore" (an empty string disables it) and keeps at most "maxEvents"
+// of the newest ones (zero or less disables it). Returns the number of removed events
+func (r *repoEventLog) PruneLogEvents(createdBefore string, maxEvents int) (int, error) {
+	var removed int
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		var keys []string
+		kept := 0
+		err := tx.Descend("log_created", func(key, value string) bool {
+			logEvent := dto.LogEvent{}
+			if err := jsoniter.UnmarshalFromString(value, &logEvent); err != nil {
+				return false
+			}
+			if (createdBefore != "" && logEvent.Created < createdBefore) || (maxEvents > 0 && kept >= maxEvents) {
+				keys = append(keys, key)
+				return true
+			}
+			kept++
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		// buntdb does not allow to modify the items while iterating
+		for _, key := range keys {
+			if _, err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// endregion =============================================================================
+
+// region ======== PRIVATE AUX ===========================================================
+
+// filterBatteryLevels returns only the battery levels of the given drone
+func filterBatteryLevels(levels []dto.DroneBatteryLevel, serialNumber string) []dto.DroneBatteryLevel {
+	filtered := make([]dto.DroneBatteryLevel, 0, 1)
+	for _, level := range levels {
+		if level.SerialNumber == serialNumber {
+			filtered = append(filtered, level)
+		}
+	}
+	return filtered
+}
+
+// endregion =============================================================================
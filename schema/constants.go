@@ -28,6 +28,15 @@ const (
 	ErrDroneMaximumLoadWeightExceededKey = "err.drone_maximum_load_weight_exceeded"
 	ErrDroneVeryLowBatteryKey            = "err.drone_very_low_battery"
 	ErrDroneBusyKey                      = "err.drone_busy"
+	ErrDroneStateTransitionKey           = "err.drone_illegal_state_transition"
+	ErrDroneNotLoadedKey                 = "err.drone_not_loaded"
+	ErrMedicationLoadedKey               = "err.medication_loaded"
+	ErrDroneDecommissionedKey            = "err.drone_decommissioned"
+	ErrDroneLoadedKey                    = "err.drone_loaded"
+	ErrDroneFleetFullKey                 = "err.drone_fleet_full"
+	ErrLastAdminKey                      = "err.last_admin"
+	ErrOrderClosedKey                    = "err.order_closed"
+	ErrDroneUnavailableKey               = "err.drone_unavailable"
 	ErrBuntdbIndex                       = "err.database_index_related"
 	ErrStorageProc                       = "err.storage_service_processing"
 	ErrVal                               = "err.invalid_data"
@@ -61,9 +70,42 @@ const (
 var (
 	// ErrDroneMaximumLoadWeightExceeded the drone from being loaded with more weight that it can carry
 	ErrDroneMaximumLoadWeightExceeded = errors.New("maximum load weight exceeded")
-	ErrDroneVeryLowBattery            = errors.New("battery level is **below 25%**")
+	// ErrDroneVeryLowBattery when the battery level is below the level required to load the drone (BatteryLoadLevel)
+	ErrDroneVeryLowBattery = errors.New("battery level too low to load the drone")
 	// ErrDroneBusy when the state of the drone is different from IDLE
 	ErrDroneBusy = errors.New("drone busy, select a drone in IDLE mode")
+	// ErrDroneNotLoaded when the state of the drone is different from LOADING or LOADED
+	ErrDroneNotLoaded = errors.New("drone not loaded, select a drone in LOADING or LOADED mode")
+	// ErrDroneEmpty when a drone without medication items is moved to LOADING or LOADED
+	ErrDroneEmpty = errors.New("drone without medication items, load them before it can be LOADING or LOADED")
+	// ErrDroneLoaded when a drone still carries medication items
+	ErrDroneLoaded = errors.New("drone carrying medication items, unload it first")
+	// ErrDroneExists when a new drone uses the serial number of a registered one
+	ErrDroneExists = errors.New("a drone with the same serial number already exists")
+	// ErrUserExists when a new user uses the username of an existing one
+	ErrUserExists = errors.New("a user with the same username already exists")
+	// ErrLastAdmin when disabling or demoting a user would leave no enabled admin to manage the users
+	ErrLastAdmin = errors.New("the change would leave no enabled admin to manage the users")
+	// ErrRefreshTokenReused when a rotated refresh token is used again, all the tokens of its family are revoked
+	ErrRefreshTokenReused = errors.New("the refresh token was already used, its family of tokens is revoked")
+	// ErrDroneFleetFull when a new drone exceeds the maximum size of the fleet
+	ErrDroneFleetFull = errors.New("the fleet has reached its maximum number of drones")
+	// ErrInvalidCursor when the pagination cursor was not returned by a previous page
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+	// ErrDroneDecommissioned when the drone was removed from the fleet, only its tombstone is kept
+	ErrDroneDecommissioned = errors.New("drone decommissioned")
+	// ErrMedicationItemNotFound when a requested medication item does not exist
+	ErrMedicationItemNotFound = errors.New("at least one of the medication items does not exist")
+	// ErrMedicationLoaded when a medication can not be removed because a drone is carrying it
+	ErrMedicationLoaded = errors.New("the medication is loaded on at least one drone")
+	// ErrMedicationExists when a new medication uses the code of an existing one
+	ErrMedicationExists = errors.New("a medication with the same code already exists")
+	// ErrDroneStateTransition when the drone state machine does not allow the requested move
+	ErrDroneStateTransition = errors.New("illegal drone state transition")
+	// ErrNoDroneAvailable when no drone can start loading (IDLE with a battery level of at least BatteryLoadLevel)
+	ErrNoDroneAvailable = errors.New("there is no IDLE drone with a battery level high enough to be loaded")
+	// ErrOrderClosed when the order was already delivered or cancelled
+	ErrOrderClosed = errors.New("the order was already delivered or cancelled")
 )
 
 // endregion =============================================================================
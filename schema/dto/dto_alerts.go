@@ -0,0 +1,50 @@
+package dto
+
+type AlertStatus uint
+
+const (
+	AlertRecorded AlertStatus = iota
+	AlertPending
+	AlertDelivered
+	AlertFailed
+)
+
+func (alertStatus AlertStatus) String() string {
+	names := []string{"RECORDED", "PENDING", "DELIVERED", "FAILED"}
+	if alertStatus < AlertRecorded || alertStatus > AlertFailed {
+		return "unknown"
+	}
+	return names[alertStatus]
+}
+
+// Alert model
+// @Description a drone battery level dropped below an alert threshold, the alert is posted to the configured webhooks
+// @Description status: RECORDED (0) no webhook configured, PENDING (1), DELIVERED (2), FAILED (3) after the maximum attempts
+type Alert struct {
+	ID              string      `json:"id"`
+	SerialNumber    string      `json:"serialNumber"`
+	Threshold       float64     `json:"threshold"`
+	BatteryCapacity float64     `json:"batteryCapacity"`
+	Created         string      `json:"created"`
+	Status          AlertStatus `json:"status"`
+	Attempts        int         `json:"attempts"`
+	NextAttempt     string      `json:"nextAttempt,omitempty"`
+	Webhooks        []string    `json:"webhooks,omitempty"` // webhooks that have not acknowledged the alert yet
+	LastError       string      `json:"lastError,omitempty"`
+}
+
+// AlertPayload model
+// @Description JSON body posted to the webhooks, it is signed with HMAC-SHA256 in the X-Signature-256 header
+type AlertPayload struct {
+	ID              string  `json:"id"`
+	SerialNumber    string  `json:"serialNumber"`
+	Threshold       float64 `json:"threshold"`
+	BatteryCapacity float64 `json:"batteryCapacity"`
+	Created         string  `json:"created"`
+}
+
+// AlertFilter optional filters of the alerts list
+type AlertFilter struct {
+	SerialNumber string
+	Status       *AlertStatus
+}
@@ -5,11 +5,86 @@ package dto
 type UserCredIn struct {
 	Username string `example:"richard.sargon@meinermail.com" validate:"required,ascii,gte=3,lte=60"`
 	Password string `example:"password1" validate:"required,ascii,gte=3,lte=20"`
+	Scope    string `example:"drones:read orders:read" validate:"omitempty,ascii,lte=200"` // space delimited, all the scopes allowed to the role are granted when it is empty
 }
 
 type GrantIntentResponse struct {
 	Identifier string // if we use `json:"<source_name>"` we can map any source to a common particular / internal struct field as Identifier used here
 	DID        string
+	Role       string
+	Scope      []string // granted scopes, the requested scopes trimmed to the ones allowed to the role
+}
+
+// TokenResponse model
+// @Description the access token and the refresh token of an authenticated user, expiresIn is the lifetime (in seconds) of the access token
+type TokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType" example:"Bearer"`
+	ExpiresIn    int    `json:"expiresIn" example:"900"`
+	Scope        string `json:"scope" example:"drones:read orders:read"` // granted scopes, space delimited
+}
+
+// RequestRefresh model
+// @Description a refresh token, it is rotated: the response carries a new refresh token and this one can not be used again
+type RequestRefresh struct {
+	RefreshToken string `json:"refreshToken" validate:"required,ascii,lte=100"`
+}
+
+// RefreshToken a refresh token stored server-side, the tokens rotated from the same authentication share the family
+type RefreshToken struct {
+	ID         string   `json:"id"` // SHA-256 of the token, the token itself is never stored
+	Family     string   `json:"family"`
+	Username   string   `json:"username"`
+	Scope      []string `json:"scope"`
+	Created    string   `json:"created"`
+	Expires    string   `json:"expires"`
+	ReplacedBy string   `json:"replacedBy,omitempty"` // id of the token that rotated this one, it is set on its first use
+}
+
+// scopes of the access token, every route group declares the scopes it requires
+const (
+	ScopeDronesRead       = "drones:read"       // the drones, their battery, the alerts and the event log
+	ScopeDronesWrite      = "drones:write"      // registers, updates and decommissions the drones
+	ScopeMedicationsRead  = "medications:read"  // the medications and the items loaded in the drones
+	ScopeMedicationsWrite = "medications:write" // manages the medications and loads them in the drones
+	ScopeOrdersRead       = "orders:read"       // the orders
+	ScopeOrdersWrite      = "orders:write"      // creates, cancels and dispatches the orders
+	ScopeAdminUsers       = "admin:users"       // manages the users
+	ScopeAdminDatabase    = "admin:database"    // manages the database
+)
+
+// roleScopes the scopes allowed to each role
+var roleScopes = map[string][]string{
+	RoleViewer:     {ScopeDronesRead, ScopeMedicationsRead, ScopeOrdersRead},
+	RoleDispatcher: {ScopeDronesRead, ScopeMedicationsRead, ScopeOrdersRead, ScopeDronesWrite, ScopeMedicationsWrite, ScopeOrdersWrite},
+	RoleAdmin:      {ScopeDronesRead, ScopeMedicationsRead, ScopeOrdersRead, ScopeDronesWrite, ScopeMedicationsWrite, ScopeOrdersWrite, ScopeAdminUsers, ScopeAdminDatabase},
+}
+
+// GrantScopes trims the requested scopes to the ones allowed to the role, the unknown and repeated scopes are
+// dropped. All the allowed scopes are granted when none is requested
+func GrantScopes(role string, requested []string) []string {
+	allowed := roleScopes[role]
+	if len(requested) == 0 {
+		return append([]string{}, allowed...)
+	}
+	granted := []string{}
+	for _, scope := range allowed {
+		if HasScope(requested, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
+
+// HasScope reports whether the scopes include the required scope
+func HasScope(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if scope == required {
+			return true
+		}
+	}
+	return false
 }
 
 // AccessTokenData using by this REST Api (HLF client node) to grant access to the resources
@@ -18,13 +93,9 @@ type AccessTokenData struct {
 	Claims InjectedParam
 }
 
-// Claims user claims
-type Claims struct {
-	Sub string
-	Rol string
-}
-
+// InjectedParam user claims of the access token, the role is checked by the role middleware
 type InjectedParam struct {
 	Did      string
 	Username string
+	Role     string
 }
\ No newline at end of file
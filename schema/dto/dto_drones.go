@@ -1,5 +1,7 @@
 package dto
 
+import "encoding/json"
+
 type DroneState uint
 
 const (
@@ -49,6 +51,34 @@ type RequestDrone struct {
 	State           DroneState `json:"state" validate:"drone_state_validation"`
 }
 
+// RequestBatteryLevel model
+// @Description battery level reported by a drone
+type RequestBatteryLevel struct {
+	BatteryCapacity *float64 `json:"batteryCapacity" validate:"required,gte=0,lte=100"`
+}
+
+// RequestTelemetry model
+// @Description battery level and state of a drone reported by a ground station
+type RequestTelemetry struct {
+	SerialNumber    string      `json:"serialNumber" validate:"required,max=100"`
+	BatteryCapacity *float64    `json:"batteryCapacity" validate:"required,gte=0,lte=100"`
+	State           *DroneState `json:"state" validate:"required,drone_state_validation"`
+}
+
+// TelemetryResult model
+// @Description result of an entry of the telemetry, the problem is set when the entry was not applied
+type TelemetryResult struct {
+	SerialNumber string   `json:"serialNumber"`
+	Status       uint     `json:"status"`
+	Problem      *Problem `json:"problem,omitempty"`
+}
+
+// RequestTransition model
+// @Description target state of a drone state transition
+type RequestTransition struct {
+	State DroneState `json:"state" validate:"drone_state_validation"`
+}
+
 // Drone model
 // @Description Drone item information
 type Drone struct {
@@ -57,17 +87,56 @@ type Drone struct {
 	WeightLimit     float64    `json:"weightLimit"`
 	BatteryCapacity float64    `json:"batteryCapacity" validate:"gte=0,lte=100"`
 	State           DroneState `json:"state" validate:"drone_state_validation"`
+	Decommissioned  string     `json:"decommissioned,omitempty"` // decommission date (RFC 3339), the drone is kept as a tombstone
+}
+
+// AvailableDrone model
+// @Description drone able to carry a payload, and its remaining weight capacity once it is loaded
+type AvailableDrone struct {
+	Drone
+	RemainingCapacity float64 `json:"remainingCapacity"`
 }
 
 // Medication model
 // @Description Medication item information
 type Medication struct {
 	Name   string  `json:"name" validate:"medication_name_validation"`
-	Weight float64 `json:"weight"`
+	Weight float64 `json:"weight" validate:"gt=0"`
 	Code   string  `json:"code" validate:"medication_code_validation"` // we assume that the code is unique
 	Image  string  `json:"image" validate:"base64"`
 }
 
+// MedicationItem model
+// @Description medication item code and the number of units loaded by a drone
+type MedicationItem struct {
+	Code     string `json:"code" validate:"required,medication_code_validation"`
+	Quantity int    `json:"quantity" validate:"gte=1"`
+}
+
+// UnmarshalJSON accepts a medication item object or a bare code, a bare code or
+// an object without quantity means a single unit
+func (item *MedicationItem) UnmarshalJSON(data []byte) error {
+	var code string
+	if err := json.Unmarshal(data, &code); err == nil {
+		*item = MedicationItem{Code: code, Quantity: 1}
+		return nil
+	}
+	type medicationItem MedicationItem // without methods, to avoid the recursion
+	aux := medicationItem{Quantity: 1}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*item = MedicationItem(aux)
+	return nil
+}
+
+// LoadedMedications model
+// @Description medication items loaded by a drone and the total payload weight
+type LoadedMedications struct {
+	Items       []MedicationItem `json:"items"`
+	TotalWeight float64          `json:"totalWeight"`
+}
+
 const (
 	RegexpMedicationName  = "^[a-zA-Z0-9_-]*$" // allowed only letters, numbers, ‘-‘, ‘_’
 	RegexpMedicationCode  = "^[A-Z0-9_]*$"     // allowed only upper case letters, underscore and numbers
@@ -80,12 +149,59 @@ type DroneBatteryLevel struct {
 	BatteryCapacity float64 `json:"batteryCapacity"`
 }
 
+// sort fields of the drones list
+const (
+	DroneSortBattery = "battery"
+	DroneSortSerial  = "serial"
+	DroneSortModel   = "model"
+)
+
+// DroneFilter filters, sort and pagination used to query the drones
+type DroneFilter struct {
+	State                 *DroneState // nil means any state
+	Model                 *DroneModel // nil means any model
+	MinBattery            *float64    // inclusive lower bound of the battery capacity, nil means unbounded
+	MaxBattery            *float64    // inclusive upper bound of the battery capacity, nil means unbounded
+	IncludeDecommissioned bool
+	Sort                  string // DroneSortBattery, DroneSortSerial or DroneSortModel
+	Desc                  bool
+	Limit                 int    // zero means no limit
+	Cursor                string // position after which the page starts, returned by the previous page
+}
+
+// DronesPage model
+// @Description page of drones, nextCursor is empty on the last page
+type DronesPage struct {
+	Drones     []Drone `json:"drones"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// LogEventDateLayout layout of the LogEvent creation date, it can be sorted as a string
+const LogEventDateLayout = "20060102-150405"
+
+// sources of the log events
+const (
+	LogEventSourceCron      = "cron"      // periodic snapshot of the battery levels of the fleet
+	LogEventSourceDrone     = "drone"     // battery levels reported by the drones
+	LogEventSourceTelemetry = "telemetry" // battery levels reported by the ground stations
+)
+
 type LogEvent struct {
 	Created             string              `json:"created"`
 	UUID                string              `json:"uuid"`
+	Source              string              `json:"source,omitempty"`
 	DronesBatteryLevels []DroneBatteryLevel `json:"dronesBatteryLevels"`
 }
 
+// LogEventFilter filters used to query the history/audit event log
+type LogEventFilter struct {
+	From         string // inclusive lower bound of the creation date (LogEventDateLayout), empty means unbounded
+	To           string // inclusive upper bound of the creation date (LogEventDateLayout), empty means unbounded
+	SerialNumber string // narrows the battery levels to the given drone
+	Offset       int
+	Limit        int // zero means no limit
+}
+
 type StatusMsg struct {
 	OK bool `json:"ok"`
 }
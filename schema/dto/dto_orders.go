@@ -0,0 +1,93 @@
+package dto
+
+type OrderStatus uint
+
+const (
+	OrderLoaded OrderStatus = iota
+	OrderDelivering
+	OrderDelivered
+	OrderCancelled
+)
+
+func (orderStatus OrderStatus) String() string {
+	names := []string{"LOADED", "DELIVERING", "DELIVERED", "CANCELLED"}
+	if orderStatus < OrderLoaded || orderStatus > OrderCancelled {
+		return "unknown"
+	}
+	return names[orderStatus]
+}
+
+// RequestOrder model
+// @Description order request, the drone is loaded with the medication items when the order is created
+type RequestOrder struct {
+	SerialNumber string           `json:"serialNumber" validate:"required,max=100"`
+	Destination  string           `json:"destination" validate:"required,max=200"`
+	Items        []MedicationItem `json:"items" validate:"required,min=1,dive"`
+}
+
+// strategies picking the drone of a dispatch
+const (
+	DispatchBestFit        = "bestFit"        // the drone with the least remaining capacity after the load
+	DispatchHighestBattery = "highestBattery" // the drone with the highest battery level
+	DispatchRoundRobin     = "roundRobin"     // the drones take turns ordered by serial number
+)
+
+// RequestDispatch model
+// @Description medication items to deliver, the drone is picked by the strategy (bestFit, highestBattery or roundRobin)
+type RequestDispatch struct {
+	Destination string           `json:"destination" validate:"max=200"`
+	Items       []MedicationItem `json:"items" validate:"required,min=1,dive"`
+	Strategy    string           `json:"strategy,omitempty" validate:"omitempty,oneof=bestFit highestBattery roundRobin"`
+}
+
+// Dispatch model
+// @Description drone picked by the strategy, it is loaded with the medication items of the order
+type Dispatch struct {
+	Strategy string `json:"strategy"`
+	Drone    Drone  `json:"drone"`
+	Order    Order  `json:"order"`
+}
+
+// RequestDispatchPlan model
+// @Description medication items to deliver, they can be split across several drones
+type RequestDispatchPlan struct {
+	Destination string           `json:"destination" validate:"max=200"`
+	Items       []MedicationItem `json:"items" validate:"required,min=1,dive"`
+}
+
+// PlannedLoad model
+// @Description medication items that a drone of the plan carries, the order is set once the plan is committed
+type PlannedLoad struct {
+	SerialNumber string           `json:"serialNumber"`
+	WeightLimit  float64          `json:"weightLimit"`
+	Weight       float64          `json:"weight"`
+	Items        []MedicationItem `json:"items"`
+	Order        *Order           `json:"order,omitempty"`
+}
+
+// DispatchPlan model
+// @Description medication items split across several drones
+type DispatchPlan struct {
+	PayloadWeight float64       `json:"payloadWeight"`
+	Committed     bool          `json:"committed"`
+	Loads         []PlannedLoad `json:"loads"`
+}
+
+// Order model
+// @Description delivery of medication items by a drone to a destination
+// @Description the status follows the drone state: LOADED (0), DELIVERING (1), DELIVERED (2), CANCELLED (3)
+type Order struct {
+	ID           string           `json:"id"`
+	SerialNumber string           `json:"serialNumber"`
+	Destination  string           `json:"destination"`
+	Items        []MedicationItem `json:"items"`
+	Status       OrderStatus      `json:"status"`
+	Created      string           `json:"created"`
+	Updated      string           `json:"updated"`
+}
+
+// OrderFilter optional filters of the orders list
+type OrderFilter struct {
+	SerialNumber string
+	Status       *OrderStatus
+}
@@ -1,8 +1,43 @@
 package dto
 
-// User struct
+// roles of the users, a role has the permissions of the roles below it
+const (
+	RoleViewer     = "viewer"     // reads the fleet, the medications, the orders and the logs
+	RoleDispatcher = "dispatcher" // also operates the drones, the medications and the orders
+	RoleAdmin      = "admin"      // also manages the users
+)
+
+// roleRanks the order of the roles, an unknown role has no permission
+var roleRanks = map[string]int{RoleViewer: 1, RoleDispatcher: 2, RoleAdmin: 3}
+
+// HasRole reports whether a role has the permissions of the required role
+func HasRole(role string, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
+
+// User struct, the passphrase is the hash of the password and it is never returned by the API
 type User struct {
 	Username   string `json:"username"`
-	Passphrase string `json:"passphrase"`
+	Passphrase string `json:"passphrase,omitempty"`
 	Name       string `json:"name"`
-}
\ No newline at end of file
+	Role       string `json:"role"`
+	Disabled   bool   `json:"disabled"`
+}
+
+// RequestUser model
+// @Description new user, the password is stored as a hash. role: admin, dispatcher or viewer
+type RequestUser struct {
+	Username string `json:"username" example:"jane.doe@meinermail.com" validate:"required,ascii,gte=3,lte=60,excludesall=/"`
+	Password string `json:"password" example:"password1" validate:"required,ascii,gte=3,lte=20"`
+	Name     string `json:"name" example:"Jane Doe" validate:"required,max=100"`
+	Role     string `json:"role" example:"dispatcher" validate:"required,oneof=admin dispatcher viewer"`
+}
+
+// RequestUserUpdate model
+// @Description fields of a user to update, the omitted fields are kept
+type RequestUserUpdate struct {
+	Name     *string `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	Password *string `json:"password,omitempty" validate:"omitempty,ascii,gte=3,lte=20"`
+	Role     *string `json:"role,omitempty" validate:"omitempty,oneof=admin dispatcher viewer"`
+	Disabled *bool   `json:"disabled,omitempty"`
+}
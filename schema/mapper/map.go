@@ -2,7 +2,6 @@ package mapper
 
 import (
 	"restapi.app/schema/dto"
-	"strings"
 )
 
 // TIP ref https://hellokoding.com/crud-restful-apis-with-go-modules-wire-gin-gorm-and-mysql/
@@ -10,12 +9,12 @@ import (
 
 // ToAccessTokenDataV region ======== AUTHORIZATION =========================================================
 // dto.GrantIntentResponse to dto.AccessTokenData
-// TODO: ground the rol idea, according to the Drone app logic
+// The role of the user is embedded in the claims and the granted scopes in the scope, the role and scope middlewares
+// check them on every request
 func ToAccessTokenDataV(obj *dto.GrantIntentResponse) *dto.AccessTokenData {
-	// claims := dto.Claims{ Sub: obj.Identifier, Rol: "undefined" }
-	claims := dto.InjectedParam{Did: obj.DID, Username: obj.Identifier}
+	claims := dto.InjectedParam{Did: obj.DID, Username: obj.Identifier, Role: obj.Role}
 
-	return &dto.AccessTokenData{Scope: strings.Fields("api.drones"), Claims: claims}
+	return &dto.AccessTokenData{Scope: obj.Scope, Claims: claims}
 }
 
 // endregion =============================================================================
@@ -1,7 +1,10 @@
 package auth
 
 import (
+	"log"
+
 	"github.com/kataras/iris/v12"
+	"github.com/tidwall/buntdb"
 	"restapi.app/lib"
 	"restapi.app/repo/db"
 	"restapi.app/schema"
@@ -14,23 +17,56 @@ type Provider interface {
 
 // region ======== EVOTE AUTHENTICATION PROVIDER =========================================
 
+// dummyPassphrase argon2id hash verified for an unknown user, so a login takes the same work whether the
+// user exists or not and the response time does not reveal the usernames
+const dummyPassphrase = "$argon2id$v=19$m=65536,t=3,p=2$RXhfocOMliDVY8B4ZsjtZA$QGVpjkuDyvJ9/RR+mW2nX0zKbnCCN9999WX5c8TjWs8"
+
 type ProviderDrone struct {
 	// walletLocations string
 	repo *db.RepoDrones
 }
 
 func (p *ProviderDrone) GrantIntent(uCred *dto.UserCredIn, options interface{}) (*dto.GrantIntentResponse, *dto.Problem) {
-	// getting the users
-	user, err := (*p.repo).GetUser(uCred.Username, true)
-	if err != nil {
+	// getting the user, an unknown or disabled user gets the same answer as a wrong password
+	user, err := (*p.repo).GetUser(uCred.Username)
+	if err == buntdb.ErrNotFound {
+		_, _, _ = lib.VerifyPassword(uCred.Password, dummyPassphrase)
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrFile, schema.ErrCredsNotFound)
+	} else if err != nil {
 		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
-	checksum, _ := lib.Checksum("SHA256", []byte(uCred.Password))
-	if user.Passphrase == checksum {
-		return &dto.GrantIntentResponse{Identifier: user.Username, DID: user.Username}, nil
+	match, rehash, err := lib.VerifyPassword(uCred.Password, user.Passphrase)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrGeneric, err.Error())
+	}
+	if match && !user.Disabled {
+		if rehash {
+			p.rehashPassword(user, uCred.Password)
+		}
+		return &dto.GrantIntentResponse{Identifier: user.Username, DID: user.Username, Role: user.Role}, nil
 	}
 
 	return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrFile, schema.ErrCredsNotFound)
 }
 
+// rehashPassword replaces a legacy or outdated password hash after a successful login, a failure is only
+// logged because the user is already authenticated
+func (p *ProviderDrone) rehashPassword(user *dto.User, password string) {
+	passphrase, err := lib.HashPassword(password)
+	if err != nil {
+		log.Printf("error rehashing the password of the user '%s': %v", user.Username, err)
+		return
+	}
+	_, err = (*p.repo).UpdateUser(user.Username, func(current *dto.User) error {
+		// the password could have been changed meanwhile
+		if current.Passphrase == user.Passphrase {
+			current.Passphrase = passphrase
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("error rehashing the password of the user '%s': %v", user.Username, err)
+	}
+}
+
 // endregion =============================================================================
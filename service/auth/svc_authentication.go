@@ -1,21 +1,37 @@
 package auth
 
 import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/tidwall/buntdb"
+	"restapi.app/lib"
 	"restapi.app/repo/db"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/schema/mapper"
+	"restapi.app/service/utils"
 )
 
 type SvcAuthentication struct {
 	AuthProviders map[string]Provider // similar to slices, maps are reference types.
+
+	appConf *utils.SvcConfig
+	repo    *db.RepoDrones
 }
 
 // NewSvcAuthentication creates the authentication service. It provides the methods to make the
-// authentication intent with the register providers.
+// authentication intent with the register providers, and to issue and refresh the tokens.
 //
 // - providers [Array] ~ Maps of providers string token / identifiers
 //
-// - conf [*SvcConfig] ~ App conf instance pointer
-func NewSvcAuthentication(providers map[string]bool, repoUser *db.RepoDrones) *SvcAuthentication {
-	k := &SvcAuthentication{AuthProviders: make(map[string]Provider)}
+// - appConf [*SvcConfig] ~ App conf instance pointer
+//
+// - repoUser [*db.RepoDrones] ~ Drones repository instance, it stores the users and the refresh tokens
+func NewSvcAuthentication(providers map[string]bool, appConf *utils.SvcConfig, repoUser *db.RepoDrones) *SvcAuthentication {
+	k := &SvcAuthentication{AuthProviders: make(map[string]Provider), appConf: appConf, repo: repoUser}
 
 	for v := range providers {
 		k.AuthProviders[v] = &ProviderDrone{
@@ -25,3 +41,86 @@ func NewSvcAuthentication(providers map[string]bool, repoUser *db.RepoDrones) *S
 
 	return k
 }
+
+// IssueTokens creates the access token of an authenticated user, and the refresh token that starts a new family
+func (s *SvcAuthentication) IssueTokens(granted *dto.GrantIntentResponse) (*dto.TokenResponse, *dto.Problem) {
+	refreshToken, id, err := lib.NewRefreshToken()
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrJwtGen, err.Error())
+	}
+	token := s.newRefreshToken(id, granted.Identifier, granted.Scope)
+	token.Family = lib.GenerateUUIDStr()
+	if err = (*s.repo).CreateRefreshToken(token, s.appConf.RefreshTokenMaxAge()); err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+
+	return s.mkTokens(granted, refreshToken)
+}
+
+// RefreshTokens rotates a refresh token and creates a new access token, with the current role of the user. Using
+// a rotated refresh token again revokes its family, the tokens of a disabled user are revoked too
+func (s *SvcAuthentication) RefreshTokens(refreshToken string) (*dto.TokenResponse, *dto.Problem) {
+	var nextToken string
+	used, err := (*s.repo).RotateRefreshToken(lib.RefreshTokenID(refreshToken), s.appConf.RefreshTokenMaxAge(), func(current *dto.RefreshToken) (*dto.RefreshToken, error) {
+		token, id, err := lib.NewRefreshToken()
+		if err != nil {
+			return nil, err
+		}
+		nextToken = token
+		return s.newRefreshToken(id, current.Username, current.Scope), nil
+	})
+	if err == buntdb.ErrNotFound {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, "the refresh token is invalid or expired")
+	} else if err == schema.ErrRefreshTokenReused {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, err.Error())
+	} else if err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+
+	user, err := (*s.repo).GetUser(used.Username)
+	if err != nil && err != buntdb.ErrNotFound {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	if err == buntdb.ErrNotFound || user.Disabled {
+		if err = (*s.repo).RevokeRefreshTokens(used.Family); err != nil {
+			log.Printf("error revoking the refresh tokens of the family %s: %v", used.Family, err)
+		}
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrFile, schema.ErrCredsNotFound)
+	}
+
+	// the role could have changed since the authentication, the scopes are trimmed again
+	granted := &dto.GrantIntentResponse{Identifier: user.Username, DID: user.Username, Role: user.Role, Scope: dto.GrantScopes(user.Role, used.Scope)}
+	if len(granted.Scope) == 0 {
+		return nil, lib.NewProblem(iris.StatusForbidden, schema.ErrUnauthorized, "none of the requested scopes is allowed to the user")
+	}
+	return s.mkTokens(granted, nextToken)
+}
+
+// newRefreshToken the record of a new refresh token
+func (s *SvcAuthentication) newRefreshToken(id string, username string, scope []string) *dto.RefreshToken {
+	now := time.Now().UTC()
+	return &dto.RefreshToken{
+		ID:       id,
+		Username: username,
+		Scope:    scope,
+		Created:  now.Format(time.RFC3339),
+		Expires:  now.Add(s.appConf.RefreshTokenMaxAge()).Format(time.RFC3339),
+	}
+}
+
+// mkTokens signs the access token and returns it with the refresh token
+func (s *SvcAuthentication) mkTokens(granted *dto.GrantIntentResponse, refreshToken string) (*dto.TokenResponse, *dto.Problem) {
+	tokenData := mapper.ToAccessTokenDataV(granted)
+	accessToken, err := lib.MkAccessToken(tokenData, []byte(s.appConf.JWTSignKey), s.appConf.TkMaxAge)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrJwtGen, err.Error())
+	}
+
+	return &dto.TokenResponse{
+		AccessToken:  string(accessToken),
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    s.appConf.TkMaxAge * 60,
+		Scope:        strings.Join(granted.Scope, " "),
+	}, nil
+}
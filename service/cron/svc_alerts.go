@@ -0,0 +1,192 @@
+package cron
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/kataras/iris/v12"
+	"restapi.app/lib"
+	"restapi.app/repo/db"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+// HeaderSignature header with the HMAC-SHA256 signature of the alert posted to a webhook
+const HeaderSignature = "X-Signature-256"
+
+// ISvcAlerts Alerts service interface, it delivers the battery alerts to the configured webhooks
+type ISvcAlerts interface {
+	StartAlerts() error
+	StopAlerts()
+	GetAlertsSvc(filter *dto.AlertFilter) (*[]dto.Alert, *dto.Problem)
+}
+
+type svcAlerts struct {
+	svcConf     *utils.SvcConfig
+	reposDrones *db.RepoDrones
+	scheduler   *gocron.Scheduler
+	client      *http.Client
+	now         func() time.Time // clock used to schedule the retries, it can be replaced in tests
+
+	mu sync.Mutex
+}
+
+// NewSvcAlerts instantiate the Alerts service
+func NewSvcAlerts(svcConf *utils.SvcConfig, reposDrones *db.RepoDrones) ISvcAlerts {
+	return &svcAlerts{
+		svcConf:     svcConf,
+		reposDrones: reposDrones,
+		scheduler:   gocron.NewScheduler(time.UTC),
+		client:      &http.Client{Timeout: time.Duration(svcConf.WebhookTimeout) * time.Second},
+		now:         time.Now,
+	}
+}
+
+// StartAlerts schedules the delivery of the pending alerts, it is started only if there are webhooks in configuration
+func (s *svcAlerts) StartAlerts() error {
+	if len(s.svcConf.WebhookURLs) == 0 {
+		return nil
+	}
+	log.Printf("schedules the delivery of the alerts to %d webhooks with an interval: %d seconds", len(s.svcConf.WebhookURLs), s.svcConf.AlertEveryTime)
+
+	_, err := s.scheduler.Every(s.svcConf.AlertEveryTime).Seconds().WaitForSchedule().Do(s.deliver)
+	if err != nil {
+		return err
+	}
+	// starts the scheduler asynchronously
+	s.scheduler.StartAsync()
+	return nil
+}
+
+// StopAlerts stops the delivery of the alerts, it waits for a running delivery to finish
+func (s *svcAlerts) StopAlerts() {
+	s.scheduler.Stop()
+}
+
+// GetAlertsSvc get the alerts, optionally filtered by drone and status
+func (s *svcAlerts) GetAlertsSvc(filter *dto.AlertFilter) (*[]dto.Alert, *dto.Problem) {
+	res, err := (*s.reposDrones).GetAlerts(filter)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	return res, nil
+}
+
+// deliver posts the pending alerts whose next attempt is due to the webhooks that have not acknowledged them yet.
+// After a failed attempt the alert is retried with an exponential backoff, until the maximum attempts
+func (s *svcAlerts) deliver() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := dto.AlertPending
+	alerts, err := (*s.reposDrones).GetAlerts(&dto.AlertFilter{Status: &pending})
+	if err != nil {
+		log.Println("alerts error getting the pending alerts: ", err)
+		return
+	}
+
+	now := s.now().UTC()
+	for i := range *alerts {
+		alert := &(*alerts)[i]
+		if next, err := time.Parse(time.RFC3339, alert.NextAttempt); err == nil && next.After(now) {
+			continue
+		}
+
+		failed, lastErr := s.post(alert)
+		_, err = (*s.reposDrones).UpdateAlert(alert.ID, func(current *dto.Alert) error {
+			if current.Status != dto.AlertPending {
+				return errUnchanged
+			}
+			current.Attempts++
+			current.Webhooks = failed
+			current.NextAttempt = ""
+			current.LastError = ""
+			if lastErr != nil {
+				current.LastError = lastErr.Error()
+			}
+			switch {
+			case len(failed) == 0:
+				current.Status = dto.AlertDelivered
+			case current.Attempts >= s.svcConf.WebhookMaxAttempts:
+				current.Status = dto.AlertFailed
+			default:
+				current.NextAttempt = now.Add(s.backoff(current.Attempts)).Format(time.RFC3339)
+			}
+			return nil
+		})
+		if err != nil && err != errUnchanged {
+			log.Printf("alerts error updating the alert %s: %v", alert.ID, err)
+		}
+	}
+}
+
+// post sends an alert to its webhooks, it returns the webhooks that failed and the last error
+func (s *svcAlerts) post(alert *dto.Alert) ([]string, error) {
+	body, err := jsoniter.Marshal(dto.AlertPayload{
+		ID:              alert.ID,
+		SerialNumber:    alert.SerialNumber,
+		Threshold:       alert.Threshold,
+		BatteryCapacity: alert.BatteryCapacity,
+		Created:         alert.Created,
+	})
+	if err != nil {
+		return alert.Webhooks, err
+	}
+	signature := "sha256=" + signAlert(s.svcConf.WebhookSecret, body)
+
+	var failed []string
+	var lastErr error
+	for _, url := range alert.Webhooks {
+		if err = s.postWebhook(url, body, signature); err != nil {
+			log.Printf("alerts error posting the alert %s to %s: %v", alert.ID, url, err)
+			failed = append(failed, url)
+			lastErr = err
+		}
+	}
+	return failed, lastErr
+}
+
+// postWebhook posts a signed body to a webhook, any status other than 2xx is an error
+func (s *svcAlerts) postWebhook(url string, body []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, signature)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// backoff the time to wait before the next attempt, the configured backoff doubles after every failed attempt
+func (s *svcAlerts) backoff(attempts int) time.Duration {
+	return time.Duration(s.svcConf.WebhookBackoff) * time.Second << (attempts - 1)
+}
+
+// signAlert the hex encoded HMAC-SHA256 of the body with the webhook secret
+func signAlert(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,122 @@
+package cron
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+func TestAlertsDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var flakyHits, downHits int
+	var body []byte
+	var signature string
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		flakyHits++
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(HeaderSignature)
+		// the first attempt fails, the retry is acknowledged
+		if flakyHits == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		downHits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+	svcConf.LogDBPath = filepath.Join(t.TempDir(), "event_log.db")
+	svcConf.AlertThresholds = []float64{20}
+	svcConf.WebhookURLs = []string{flaky.URL, down.URL}
+	svcConf.WebhookSecret = "secret"
+	svcConf.WebhookMaxAttempts = 3
+	svcConf.WebhookBackoff = 10
+
+	repoDrones, _ := openRepos(t, svcConf)
+	if err := repoDrones.RegisterDrone(&dto.Drone{SerialNumber: "drone01", WeightLimit: 500, BatteryCapacity: 30, State: dto.IDLE}, 0); err != nil {
+		t.Fatalf("error registering the drone: %v", err)
+	}
+	// the battery level drops below the threshold once, then it stays below
+	for _, battery := range []float64{25, 18, 12} {
+		_, err := repoDrones.UpdateDrone("drone01", func(drone *dto.Drone, _ []dto.MedicationItem) (*dto.Drone, error) {
+			drone.BatteryCapacity = battery
+			return drone, nil
+		})
+		if err != nil {
+			t.Fatalf("error updating the drone: %v", err)
+		}
+	}
+	alerts, err := repoDrones.GetAlerts(&dto.AlertFilter{})
+	if err != nil || len(*alerts) != 1 {
+		t.Fatalf("expected a single alert, got %v (%v)", alerts, err)
+	}
+	id := (*alerts)[0].ID
+
+	now := time.Date(2022, 8, 26, 0, 0, 0, 0, time.UTC)
+	svc := NewSvcAlerts(svcConf, &repoDrones).(*svcAlerts)
+	svc.now = func() time.Time { return now }
+
+	expect := func(step int, status dto.AlertStatus, attempts int, webhooks int) {
+		alerts, err := repoDrones.GetAlerts(&dto.AlertFilter{})
+		if err != nil {
+			t.Fatalf("step #%d: error getting the alerts: %v", step, err)
+		}
+		alert := (*alerts)[0]
+		if alert.Status != status || alert.Attempts != attempts || len(alert.Webhooks) != webhooks {
+			t.Errorf("step #%d: the alert must be %s after %d attempts with %d webhooks left, got %+v", step, status, attempts, webhooks, alert)
+		}
+	}
+
+	svc.deliver()
+	expect(1, dto.AlertPending, 1, 2)
+
+	// the retry is not due yet
+	svc.deliver()
+	expect(2, dto.AlertPending, 1, 2)
+
+	now = now.Add(10 * time.Second)
+	svc.deliver()
+	expect(3, dto.AlertPending, 2, 1)
+
+	// the backoff doubled
+	now = now.Add(19 * time.Second)
+	svc.deliver()
+	expect(4, dto.AlertPending, 2, 1)
+	now = now.Add(time.Second)
+	svc.deliver()
+	expect(5, dto.AlertFailed, 3, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if flakyHits != 2 || downHits != 3 {
+		t.Errorf("expected 2 posts to the flaky webhook and 3 to the down one, got %d and %d", flakyHits, downHits)
+	}
+	mac := hmac.New(sha256.New, []byte(svcConf.WebhookSecret))
+	mac.Write(body)
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != expected {
+		t.Errorf("the alert must be signed with %s, got %s", expected, signature)
+	}
+	payload := dto.AlertPayload{}
+	if err = json.Unmarshal(body, &payload); err != nil || payload.ID != id || payload.SerialNumber != "drone01" || payload.Threshold != 20 || payload.BatteryCapacity != 18 {
+		t.Errorf("unexpected alert payload %s (%v)", body, err)
+	}
+}
@@ -1,29 +1,40 @@
 package cron
 
 import (
-	"github.com/go-co-op/gocron"
 	"log"
+	"sort"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/kataras/iris/v12"
+	"restapi.app/lib"
 	"restapi.app/repo/db"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
 	"restapi.app/service/utils"
-	"time"
 )
 
 // ISvcEventLog EventLog request service interface
 type ISvcEventLog interface {
 	MeinerCronJob() error
+	StopCronJob()
+	GetLogEventsSvc(filter *dto.LogEventFilter) (*[]dto.LogEvent, *dto.Problem)
+	AddBatteryLevelsSvc(levels []dto.DroneBatteryLevel, source string) *dto.Problem
 }
 
 type svcEventLogReqs struct {
-	svcConf     *utils.SvcConfig
-	reposDrones *db.RepoDrones
+	svcConf      *utils.SvcConfig
+	reposDrones  *db.RepoDrones
+	repoEventLog *db.RepoEventLog
+	scheduler    *gocron.Scheduler
+	now          func() time.Time // clock used to date the log events, it can be replaced in tests
 }
 
 // endregion =============================================================================
 
-// NewSvcRepoEventLog instantiate the Drones request services
-func NewSvcRepoEventLog(svcConf *utils.SvcConfig) ISvcEventLog {
-	reposDrones := db.NewRepoDrones(svcConf)
-	return &svcEventLogReqs{svcConf, &reposDrones}
+// NewSvcRepoEventLog instantiate the EventLog request services
+func NewSvcRepoEventLog(svcConf *utils.SvcConfig, reposDrones *db.RepoDrones, repoEventLog *db.RepoEventLog) ISvcEventLog {
+	return &svcEventLogReqs{svcConf, reposDrones, repoEventLog, gocron.NewScheduler(time.UTC), time.Now}
 }
 
 // MeinerCronJob periodic task to check drones battery levels and create history/audit event log for this
@@ -31,20 +42,91 @@ func (e svcEventLogReqs) MeinerCronJob() error {
 	// cron job is started only if it is active in configuration
 	if e.svcConf.CronEnabled {
 		log.Printf("schedules a new periodic Job with an interval: %d seconds", e.svcConf.EveryTime)
-		cron := gocron.NewScheduler(time.UTC)
 
-		_, err := cron.Every(e.svcConf.EveryTime).Seconds().WaitForSchedule().Do(e.doFunc)
+		_, err := e.scheduler.Every(e.svcConf.EveryTime).Seconds().WaitForSchedule().Do(e.doFunc)
 		if err != nil {
 			return err
 		}
 		// starts the scheduler asynchronously
-		cron.StartAsync()
+		e.scheduler.StartAsync()
 	}
 	return nil
 }
 
+// StopCronJob stops the periodic task, it waits for a running execution to finish
+func (e svcEventLogReqs) StopCronJob() {
+	e.scheduler.Stop()
+}
+
+// doFunc take a snapshot of the battery level of every drone and save it in the event log,
+// then the retention limits are applied
 func (e svcEventLogReqs) doFunc() {
 	log.Println("cron job executing")
 
+	page, err := (*e.reposDrones).GetDrones(&dto.DroneFilter{})
+	if err != nil {
+		log.Println("cron job error getting the drones: ", err)
+		return
+	}
+	drones := &page.Drones
+
+	now := e.now().UTC()
+	levels := make([]dto.DroneBatteryLevel, 0, len(*drones))
+	for _, drone := range *drones {
+		levels = append(levels, dto.DroneBatteryLevel{SerialNumber: drone.SerialNumber, BatteryCapacity: drone.BatteryCapacity})
+	}
+	logEvent := newLogEvent(now, levels, dto.LogEventSourceCron)
+
+	if err = (*e.repoEventLog).AddLogEvent(logEvent); err != nil {
+		log.Println("cron job error writing the log event: ", err)
+		return
+	}
+
+	// retention limits
+	createdBefore := ""
+	if e.svcConf.LogMaxAge > 0 {
+		createdBefore = now.Add(-time.Duration(e.svcConf.LogMaxAge) * time.Hour).Format(dto.LogEventDateLayout)
+	}
+	removed, err := (*e.repoEventLog).PruneLogEvents(createdBefore, e.svcConf.LogMaxEvents)
+	if err != nil {
+		log.Println("cron job error applying the event log retention: ", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("cron job removed %d old log events", removed)
+	}
+
 	log.Println("cron job ending")
 }
+
+// AddBatteryLevelsSvc adds the battery levels reported at this moment to the history/audit event log
+func (e svcEventLogReqs) AddBatteryLevelsSvc(levels []dto.DroneBatteryLevel, source string) *dto.Problem {
+	logEvent := newLogEvent(e.now().UTC(), levels, source)
+	if err := (*e.repoEventLog).AddLogEvent(logEvent); err != nil {
+		return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	return nil
+}
+
+// GetLogEventsSvc get the history/audit event log, filtered and paginated
+func (e svcEventLogReqs) GetLogEventsSvc(filter *dto.LogEventFilter) (*[]dto.LogEvent, *dto.Problem) {
+	res, err := (*e.repoEventLog).GetLogEvents(filter)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	return res, nil
+}
+
+// newLogEvent create a log event with the battery levels sorted descending by battery capacity
+func newLogEvent(created time.Time, levels []dto.DroneBatteryLevel, source string) *dto.LogEvent {
+	logEvent := dto.LogEvent{
+		Created:             created.Format(dto.LogEventDateLayout),
+		UUID:                lib.GenerateUUIDFormatDate(),
+		Source:              source,
+		DronesBatteryLevels: levels,
+	}
+	sort.SliceStable(logEvent.DronesBatteryLevels, func(i, j int) bool {
+		return logEvent.DronesBatteryLevels[i].BatteryCapacity > logEvent.DronesBatteryLevels[j].BatteryCapacity
+	})
+	return &logEvent
+}
@@ -0,0 +1,240 @@
+package cron
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"restapi.app/repo/db"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+// fakeClock implements gocron.TimeWrapper, the scheduled timers only fire when the clock is advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	pending []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+func (c *fakeClock) Now(location *time.Location) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now.In(location)
+}
+
+func (c *fakeClock) Unix(sec int64, nsec int64) time.Time {
+	return time.Unix(sec, nsec)
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// AfterFunc replaces time.AfterFunc in the scheduler
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) *time.Timer {
+	c.mu.Lock()
+	c.pending = append(c.pending, fakeTimer{at: c.now.Add(d), f: f})
+	c.mu.Unlock()
+
+	// the scheduler only needs a timer it can stop
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return timer
+}
+
+// Advance moves the clock forward and fires the timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []func()
+	pending := c.pending[:0]
+	for _, t := range c.pending {
+		if !t.at.After(c.now) {
+			due = append(due, t.f)
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	c.pending = pending
+	c.mu.Unlock()
+
+	for _, f := range due {
+		f()
+	}
+}
+
+// openRepos opens the store and event log databases, they are closed when the test ends
+func openRepos(t *testing.T, svcConf *utils.SvcConfig) (db.RepoDrones, db.RepoEventLog) {
+	repoDrones, err := db.NewRepoDrones(svcConf)
+	if err != nil {
+		t.Fatalf("error opening the store database: %v", err)
+	}
+	t.Cleanup(func() { _ = repoDrones.Close() })
+
+	repoEventLog, err := db.NewRepoEventLog(svcConf)
+	if err != nil {
+		t.Fatalf("error opening the event log database: %v", err)
+	}
+	t.Cleanup(func() { _ = repoEventLog.Close() })
+
+	return repoDrones, repoEventLog
+}
+
+func TestMeinerCronJob(t *testing.T) {
+	dir := t.TempDir()
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(dir, "data.db")
+	svcConf.LogDBPath = filepath.Join(dir, "event_log.db")
+	svcConf.CronEnabled = true
+	svcConf.EveryTime = 300
+	svcConf.LogMaxEvents = 3
+
+	repoDrones, repoEventLog := openRepos(t, svcConf)
+	if err := repoDrones.PopulateDB(); err != nil {
+		t.Fatalf("error populating the database: %v", err)
+	}
+	page, err := repoDrones.GetDrones(&dto.DroneFilter{})
+	if err != nil {
+		t.Fatalf("error getting the drones: %v", err)
+	}
+	drones := &page.Drones
+
+	clock := &fakeClock{now: time.Date(2022, 8, 26, 0, 0, 0, 0, time.UTC)}
+	svc := NewSvcRepoEventLog(svcConf, &repoDrones, &repoEventLog).(*svcEventLogReqs)
+	svc.scheduler.CustomTime(clock)
+	svc.scheduler.CustomTimer(clock.AfterFunc)
+	svc.now = func() time.Time { return clock.Now(time.UTC) }
+
+	if err = svc.MeinerCronJob(); err != nil {
+		t.Fatalf("error scheduling the cron job: %v", err)
+	}
+	defer svc.StopCronJob()
+
+	for i := 1; i <= 5; i++ {
+		clock.Advance(time.Duration(svcConf.EveryTime) * time.Second)
+		expected := clock.Now(time.UTC).Format(dto.LogEventDateLayout)
+
+		// the job runs asynchronously, wait for the snapshot of this tick
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			events, err := repoEventLog.GetLogEvents(&dto.LogEventFilter{})
+			if err == nil && len(*events) > 0 && (*events)[0].Created == expected {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("tick #%d: log event created at %s not found", i, expected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	events, err := repoEventLog.GetLogEvents(&dto.LogEventFilter{})
+	if err != nil {
+		t.Fatalf("error getting the log events: %v", err)
+	}
+	if len(*events) != svcConf.LogMaxEvents {
+		t.Fatalf("expected %d log events after retention, got %d", svcConf.LogMaxEvents, len(*events))
+	}
+	if (*events)[len(*events)-1].Created != "20220826-001500" {
+		t.Errorf("the oldest log events must be removed, the oldest kept is %s", (*events)[len(*events)-1].Created)
+	}
+	for _, event := range *events {
+		if event.UUID == "" {
+			t.Errorf("log event created at %s without uuid", event.Created)
+		}
+		if len(event.DronesBatteryLevels) != len(*drones) {
+			t.Errorf("log event %s must have %d battery levels, got %d", event.UUID, len(*drones), len(event.DronesBatteryLevels))
+		}
+		for i := 1; i < len(event.DronesBatteryLevels); i++ {
+			if event.DronesBatteryLevels[i-1].BatteryCapacity < event.DronesBatteryLevels[i].BatteryCapacity {
+				t.Errorf("log event %s battery levels must be sorted descending by capacity", event.UUID)
+				break
+			}
+		}
+	}
+}
+
+func TestMeinerCronJobMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(dir, "data.db")
+	svcConf.LogDBPath = filepath.Join(dir, "event_log.db")
+	svcConf.LogMaxAge = 1
+
+	repoDrones, repoEventLog := openRepos(t, svcConf)
+	for _, created := range []string{"20220826-000000", "20220826-003000", "20220826-010000"} {
+		if err := repoEventLog.AddLogEvent(&dto.LogEvent{Created: created, UUID: created}); err != nil {
+			t.Fatalf("error adding the log event: %v", err)
+		}
+	}
+
+	svc := NewSvcRepoEventLog(svcConf, &repoDrones, &repoEventLog).(*svcEventLogReqs)
+	svc.now = func() time.Time { return time.Date(2022, 8, 26, 1, 15, 0, 0, time.UTC) }
+	svc.doFunc()
+
+	events, err := repoEventLog.GetLogEvents(&dto.LogEventFilter{})
+	if err != nil {
+		t.Fatalf("error getting the log events: %v", err)
+	}
+	// the snapshot just taken plus the events of the last hour
+	if len(*events) != 3 {
+		t.Fatalf("expected 3 log events after retention, got %d", len(*events))
+	}
+	if (*events)[len(*events)-1].Created != "20220826-003000" {
+		t.Errorf("events older than %d hour must be removed, the oldest kept is %s", svcConf.LogMaxAge, (*events)[len(*events)-1].Created)
+	}
+}
+
+func TestGetLogEventsSvc(t *testing.T) {
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+	svcConf.LogDBPath = filepath.Join(t.TempDir(), "event_log.db")
+
+	repoDrones, repoEventLog := openRepos(t, svcConf)
+	for i, created := range []string{"20220826-000000", "20220826-001000", "20220826-002000", "20220826-003000"} {
+		levels := []dto.DroneBatteryLevel{{SerialNumber: "drone01", BatteryCapacity: 90}}
+		// the second drone was registered later
+		if i >= 2 {
+			levels = append(levels, dto.DroneBatteryLevel{SerialNumber: "drone02", BatteryCapacity: 80})
+		}
+		if err := repoEventLog.AddLogEvent(&dto.LogEvent{Created: created, UUID: created, DronesBatteryLevels: levels}); err != nil {
+			t.Fatalf("error adding the log event: %v", err)
+		}
+	}
+
+	svc := NewSvcRepoEventLog(svcConf, &repoDrones, &repoEventLog)
+	cases := []struct {
+		name     string
+		filter   dto.LogEventFilter
+		expected []string
+	}{
+		{"all", dto.LogEventFilter{}, []string{"20220826-003000", "20220826-002000", "20220826-001000", "20220826-000000"}},
+		{"range", dto.LogEventFilter{From: "20220826-001000", To: "20220826-002000"}, []string{"20220826-002000", "20220826-001000"}},
+		{"drone", dto.LogEventFilter{SerialNumber: "drone02"}, []string{"20220826-003000", "20220826-002000"}},
+		{"page", dto.LogEventFilter{Offset: 1, Limit: 2}, []string{"20220826-002000", "20220826-001000"}},
+	}
+	for _, c := range cases {
+		events, problem := svc.GetLogEventsSvc(&c.filter)
+		if problem != nil {
+			t.Fatalf("%s: unexpected problem %s", c.name, problem.Detail)
+		}
+		if len(*events) != len(c.expected) {
+			t.Fatalf("%s: expected %d log events, got %d", c.name, len(c.expected), len(*events))
+		}
+		for i, event := range *events {
+			if event.Created != c.expected[i] {
+				t.Errorf("%s: expected the log event %s at position %d, got %s", c.name, c.expected[i], i, event.Created)
+			}
+			if c.filter.SerialNumber != "" && (len(event.DronesBatteryLevels) != 1 || event.DronesBatteryLevels[0].SerialNumber != c.filter.SerialNumber) {
+				t.Errorf("%s: the battery levels of the log event %s must be narrowed to %s", c.name, event.Created, c.filter.SerialNumber)
+			}
+		}
+	}
+}
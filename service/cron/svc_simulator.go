@@ -0,0 +1,156 @@
+package cron
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"restapi.app/repo/db"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+// ISvcSimulator Simulator service interface, it simulates the drones of the fleet in environments without real drones
+type ISvcSimulator interface {
+	StartSimulator() error
+	StopSimulator()
+}
+
+type svcSimulator struct {
+	svcConf     *utils.SvcConfig
+	reposDrones *db.RepoDrones
+	scheduler   *gocron.Scheduler
+	now         func() time.Time // clock used to measure the time spent in a state, it can be replaced in tests
+
+	mu    sync.Mutex
+	since map[string]stateSince // state of every drone and when the simulator saw it for the first time
+}
+
+type stateSince struct {
+	state dto.DroneState
+	since time.Time
+}
+
+// simulatedCycle the next state of the delivery cycle, the drones are loaded through the API
+var simulatedCycle = map[dto.DroneState]dto.DroneState{
+	dto.LOADED:     dto.DELIVERING,
+	dto.DELIVERING: dto.DELIVERED,
+	dto.DELIVERED:  dto.RETURNING,
+	dto.RETURNING:  dto.IDLE,
+}
+
+// errUnchanged the simulation step does not change the drone, so it is not written
+var errUnchanged = errors.New("drone unchanged")
+
+// NewSvcSimulator instantiate the Simulator service
+func NewSvcSimulator(svcConf *utils.SvcConfig, reposDrones *db.RepoDrones) ISvcSimulator {
+	return &svcSimulator{
+		svcConf:     svcConf,
+		reposDrones: reposDrones,
+		scheduler:   gocron.NewScheduler(time.UTC),
+		now:         time.Now,
+		since:       make(map[string]stateSince),
+	}
+}
+
+// StartSimulator schedules the simulation steps, the simulator is started only if it is active in configuration
+func (s *svcSimulator) StartSimulator() error {
+	if !s.svcConf.SimEnabled {
+		return nil
+	}
+	log.Printf("schedules the fleet simulator with an interval: %d seconds", s.svcConf.SimEveryTime)
+
+	_, err := s.scheduler.Every(s.svcConf.SimEveryTime).Seconds().WaitForSchedule().Do(s.step)
+	if err != nil {
+		return err
+	}
+	// starts the scheduler asynchronously
+	s.scheduler.StartAsync()
+	return nil
+}
+
+// StopSimulator stops the simulation steps, it waits for a running step to finish
+func (s *svcSimulator) StopSimulator() {
+	s.scheduler.Stop()
+}
+
+// step drains the battery of the drones DELIVERING or RETURNING, charges the IDLE ones, and moves the drones
+// that spent the configured time in their state to the next state of the delivery cycle
+func (s *svcSimulator) step() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, err := (*s.reposDrones).GetDrones(&dto.DroneFilter{})
+	if err != nil {
+		log.Println("simulator error getting the drones: ", err)
+		return
+	}
+
+	now := s.now()
+	seen := make(map[string]stateSince, len(page.Drones))
+	for _, drone := range page.Drones {
+		tracked, ok := s.since[drone.SerialNumber]
+		if !ok || tracked.state != drone.State {
+			tracked = stateSince{drone.State, now}
+		}
+
+		// the drone is checked again in the update, it could have been changed through the API
+		res, err := (*s.reposDrones).UpdateDrone(drone.SerialNumber, func(current *dto.Drone, _ []dto.MedicationItem) (*dto.Drone, error) {
+			if current.State != tracked.state {
+				return nil, errUnchanged
+			}
+			return s.simulate(current, now.Sub(tracked.since))
+		})
+		switch {
+		case err == nil:
+			if res.State != tracked.state {
+				log.Printf("simulator moved the drone '%s' from %s to %s", res.SerialNumber, tracked.state, res.State)
+				tracked = stateSince{res.State, now}
+			}
+		case err != errUnchanged:
+			log.Printf("simulator error updating the drone '%s': %v", drone.SerialNumber, err)
+		}
+		seen[drone.SerialNumber] = tracked
+	}
+	// the decommissioned drones are no longer tracked
+	s.since = seen
+}
+
+// simulate applies a simulation step to a drone that spent elapsed time in its state
+func (s *svcSimulator) simulate(drone *dto.Drone, elapsed time.Duration) (*dto.Drone, error) {
+	battery := drone.BatteryCapacity
+	switch drone.State {
+	case dto.DELIVERING, dto.RETURNING:
+		battery = math.Max(0, battery-s.svcConf.SimDrainRate)
+	case dto.IDLE:
+		battery = math.Min(100, battery+s.svcConf.SimChargeRate)
+	}
+	// the battery level is kept with one decimal
+	battery = math.Round(battery*10) / 10
+
+	state := drone.State
+	if timing := s.stateTime(drone.State); timing > 0 && elapsed >= timing {
+		state = simulatedCycle[drone.State]
+	}
+
+	if battery == drone.BatteryCapacity && state == drone.State {
+		return nil, errUnchanged
+	}
+	drone.BatteryCapacity = battery
+	drone.State = state
+	return drone, nil
+}
+
+// stateTime the configured time a drone stays in a state of the delivery cycle, 0 if the drone stays in the state
+func (s *svcSimulator) stateTime(state dto.DroneState) time.Duration {
+	seconds := map[dto.DroneState]int{
+		dto.LOADED:     s.svcConf.SimLoadedTime,
+		dto.DELIVERING: s.svcConf.SimDeliveringTime,
+		dto.DELIVERED:  s.svcConf.SimDeliveredTime,
+		dto.RETURNING:  s.svcConf.SimReturningTime,
+	}[state]
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,74 @@
+package cron
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+func TestSimulatorStep(t *testing.T) {
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+	svcConf.LogDBPath = filepath.Join(t.TempDir(), "event_log.db")
+	svcConf.SimDrainRate = 2
+	svcConf.SimChargeRate = 5
+	svcConf.SimLoadedTime = 30
+	svcConf.SimDeliveringTime = 60
+
+	repoDrones, _ := openRepos(t, svcConf)
+	drones := []dto.Drone{
+		{SerialNumber: "idle", WeightLimit: 500, BatteryCapacity: 98, State: dto.IDLE},
+		{SerialNumber: "delivering", WeightLimit: 500, BatteryCapacity: 1, State: dto.DELIVERING},
+		{SerialNumber: "delivered", WeightLimit: 500, BatteryCapacity: 50, State: dto.DELIVERED}, // no timing, it stays DELIVERED
+		{SerialNumber: "loaded", WeightLimit: 500, BatteryCapacity: 80, State: dto.IDLE},
+	}
+	for i := range drones {
+		if err := repoDrones.RegisterDrone(&drones[i], 0); err != nil {
+			t.Fatalf("error registering the drone %s: %v", drones[i].SerialNumber, err)
+		}
+	}
+	if err := repoDrones.AddMedication(&dto.Medication{Code: "MED_01", Name: "Med01", Weight: 100}); err != nil {
+		t.Fatalf("error adding the medication: %v", err)
+	}
+	order, err := repoDrones.CreateOrder("loaded", "Calle 23, Vedado", []dto.MedicationItem{{Code: "MED_01", Quantity: 1}}, func(*dto.Drone) error { return nil })
+	if err != nil {
+		t.Fatalf("error creating the order: %v", err)
+	}
+
+	now := time.Date(2022, 8, 26, 0, 0, 0, 0, time.UTC)
+	svc := NewSvcSimulator(svcConf, &repoDrones).(*svcSimulator)
+	svc.now = func() time.Time { return now }
+
+	expect := func(step int, serialNumber string, battery float64, state dto.DroneState) {
+		drone, err := repoDrones.GetDrone(serialNumber)
+		if err != nil {
+			t.Fatalf("step #%d: error getting the drone %s: %v", step, serialNumber, err)
+		}
+		if drone.BatteryCapacity != battery || drone.State != state {
+			t.Errorf("step #%d: the drone %s must be %s with %.1f%%, got %s with %.1f%%", step, serialNumber, state, battery, drone.State, drone.BatteryCapacity)
+		}
+	}
+
+	svc.step()
+	expect(1, "idle", 100, dto.IDLE)
+	expect(1, "delivering", 0, dto.DELIVERING)
+	expect(1, "delivered", 50, dto.DELIVERED)
+	expect(1, "loaded", 80, dto.LOADED)
+
+	// the LOADED drone waited long enough, the order advances with it
+	now = now.Add(30 * time.Second)
+	svc.step()
+	expect(2, "loaded", 80, dto.DELIVERING)
+	if res, err := repoDrones.GetOrder(order.ID); err != nil || res.Status != dto.OrderDelivering {
+		t.Errorf("the order must be DELIVERING, got %+v (%v)", res, err)
+	}
+
+	now = now.Add(30 * time.Second)
+	svc.step()
+	expect(3, "delivering", 0, dto.DELIVERED)
+	expect(3, "loaded", 78, dto.DELIVERING)
+	expect(3, "delivered", 50, dto.DELIVERED)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+)
+
+// DispatchStrategy picks the drone of a dispatch among the candidates, the drones able to carry the payload.
+// The candidates are never empty
+type DispatchStrategy func(candidates []dto.AvailableDrone) *dto.AvailableDrone
+
+// newDispatchStrategies returns the available strategies by name, the round robin keeps its turn
+// for the lifetime of the service
+func newDispatchStrategies() map[string]DispatchStrategy {
+	return map[string]DispatchStrategy{
+		dto.DispatchBestFit:        bestFit,
+		dto.DispatchHighestBattery: highestBattery,
+		dto.DispatchRoundRobin:     (&roundRobin{}).pick,
+	}
+}
+
+// CheckDispatchStrategy checks the strategy configured for the dispatches without one, an empty strategy
+// is the best fit
+func CheckDispatchStrategy(strategy string) error {
+	if _, ok := newDispatchStrategies()[strategy]; !ok && strategy != "" {
+		return fmt.Errorf("unknown dispatch strategy %s in the configuration", strategy)
+	}
+	return nil
+}
+
+// bestFit picks the drone with the least remaining capacity after the load, so the larger drones are kept
+// for the heavier payloads. Ties are broken by the highest battery level and then by serial number
+func bestFit(candidates []dto.AvailableDrone) *dto.AvailableDrone {
+	best := &candidates[0]
+	for i := range candidates[1:] {
+		c := &candidates[i+1]
+		switch {
+		case c.RemainingCapacity != best.RemainingCapacity:
+			if c.RemainingCapacity < best.RemainingCapacity {
+				best = c
+			}
+		case c.BatteryCapacity != best.BatteryCapacity:
+			if c.BatteryCapacity > best.BatteryCapacity {
+				best = c
+			}
+		case c.SerialNumber < best.SerialNumber:
+			best = c
+		}
+	}
+	return best
+}
+
+// highestBattery picks the drone with the highest battery level, ties are broken by serial number
+func highestBattery(candidates []dto.AvailableDrone) *dto.AvailableDrone {
+	best := &candidates[0]
+	for i := range candidates[1:] {
+		c := &candidates[i+1]
+		if c.BatteryCapacity > best.BatteryCapacity || (c.BatteryCapacity == best.BatteryCapacity && c.SerialNumber < best.SerialNumber) {
+			best = c
+		}
+	}
+	return best
+}
+
+// roundRobin the drones take turns ordered by serial number, the next turn is the first candidate after
+// the last picked drone
+type roundRobin struct {
+	mu   sync.Mutex
+	last string
+}
+
+func (r *roundRobin) pick(candidates []dto.AvailableDrone) *dto.AvailableDrone {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var first, next *dto.AvailableDrone
+	for i := range candidates {
+		c := &candidates[i]
+		if first == nil || c.SerialNumber < first.SerialNumber {
+			first = c
+		}
+		if c.SerialNumber > r.last && (next == nil || c.SerialNumber < next.SerialNumber) {
+			next = c
+		}
+	}
+	// the turn goes back to the beginning
+	if next == nil {
+		next = first
+	}
+	r.last = next.SerialNumber
+	return next
+}
+
+// packLoads splits the medication items across the candidates using first fit decreasing: the heaviest medications
+// are placed first, in the drones already used before a new one is added, and the drone added is the one with the
+// largest weight limit, so the plan uses few drones. Returns ErrDroneMaximumLoadWeightExceeded if the candidates
+// can not carry the payload
+func packLoads(candidates []dto.AvailableDrone, weights map[string]float64, items []dto.MedicationItem) ([]dto.PlannedLoad, error) {
+	// the largest drones first, ties are broken by the highest battery level and then by serial number
+	drones := append([]dto.AvailableDrone(nil), candidates...)
+	sort.SliceStable(drones, func(i, j int) bool {
+		a, b := drones[i], drones[j]
+		if a.WeightLimit != b.WeightLimit {
+			return a.WeightLimit > b.WeightLimit
+		}
+		if a.BatteryCapacity != b.BatteryCapacity {
+			return a.BatteryCapacity > b.BatteryCapacity
+		}
+		return a.SerialNumber < b.SerialNumber
+	})
+	// the heaviest medications first
+	pending := append([]dto.MedicationItem(nil), items...)
+	sort.SliceStable(pending, func(i, j int) bool {
+		if weights[pending[i].Code] != weights[pending[j].Code] {
+			return weights[pending[i].Code] > weights[pending[j].Code]
+		}
+		return pending[i].Code < pending[j].Code
+	})
+	var payloadWeight float64
+	for _, item := range pending {
+		payloadWeight += weights[item.Code] * float64(item.Quantity)
+	}
+
+	loads := make([]dto.PlannedLoad, 0)
+	next := 0 // next drone added to the plan
+	for _, item := range pending {
+		weight := weights[item.Code]
+		quantity := item.Quantity
+		for i := 0; quantity > 0; i++ {
+			if i == len(loads) {
+				switch {
+				case next == len(drones):
+					return nil, fmt.Errorf("%w: the payload weighs %.2fgr, more than the available drones can carry", schema.ErrDroneMaximumLoadWeightExceeded, payloadWeight)
+				case drones[next].WeightLimit < weight:
+					return nil, fmt.Errorf("%w: the medication %s weighs %.2fgr, more than the weight limit of every available drone", schema.ErrDroneMaximumLoadWeightExceeded, item.Code, weight)
+				}
+				loads = append(loads, dto.PlannedLoad{SerialNumber: drones[next].SerialNumber, WeightLimit: drones[next].WeightLimit, Items: []dto.MedicationItem{}})
+				next++
+			}
+			load := &loads[i]
+			units := int(math.Floor((load.WeightLimit - load.Weight) / weight))
+			if units > quantity {
+				units = quantity
+			}
+			if units > 0 {
+				load.Items = append(load.Items, dto.MedicationItem{Code: item.Code, Quantity: units})
+				load.Weight += weight * float64(units)
+				quantity -= units
+			}
+		}
+	}
+	return loads, nil
+}
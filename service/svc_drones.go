@@ -1,14 +1,18 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"restapi.app/lib"
+	"sort"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/tidwall/buntdb"
 	"restapi.app/repo/db"
 	"restapi.app/schema"
 	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
 )
 
 // region ======== SETUP =================================================================
@@ -20,32 +24,60 @@ type ISvcDrones interface {
 
 	// user functions
 
-	GetUserSvc(id string, filter bool) (*dto.User, *dto.Problem)
+	GetUserSvc(username string) (*dto.User, *dto.Problem)
 	GetUsersSvc() (*[]dto.User, *dto.Problem)
+	CreateUserSvc(request *dto.RequestUser) (*dto.User, *dto.Problem)
+	UpdateUserSvc(username string, request *dto.RequestUserUpdate) (*dto.User, *dto.Problem)
+	DisableUserSvc(username string) *dto.Problem
 
 	// drone functions
 
 	GetADroneSvc(serialNumber string) (*dto.Drone, *dto.Problem)
-	GetDronesSvc(filters ...string) (*[]dto.Drone, *dto.Problem)
+	GetDronesSvc(filter *dto.DroneFilter) (*dto.DronesPage, *dto.Problem)
+	GetAvailableDronesSvc(medicationItems []dto.MedicationItem, payloadWeight float64) (*[]dto.AvailableDrone, *dto.Problem)
 	RegisterDroneSvc(drone *dto.Drone) *dto.Problem
+	ReplaceDroneSvc(drone *dto.Drone) (*dto.Drone, *dto.Problem)
+	PatchDroneSvc(serialNumber string, patch map[string]interface{}, validate func(drone *dto.RequestDrone) error) (*dto.Drone, *dto.Problem)
+	DecommissionDroneSvc(serialNumber string) *dto.Problem
 	ExistDroneSvc(serialNumber string) (bool, *dto.Problem)
+	TransitionDroneSvc(serialNumber string, state dto.DroneState) (*dto.Drone, *dto.Problem)
+	GetBatteryLevelSvc(serialNumber string) (*dto.DroneBatteryLevel, *dto.Problem)
+	UpdateBatteryLevelSvc(serialNumber string, batteryCapacity float64) (*dto.DroneBatteryLevel, *dto.Problem)
+	ApplyTelemetrySvc(telemetry []dto.RequestTelemetry, validate func(telemetry *dto.RequestTelemetry) error) (*[]dto.TelemetryResult, *dto.Problem)
 
 	// medication functions
 
 	GetMedicationsSvc() (*[]dto.Medication, *dto.Problem)
-	CheckingLoadedMedicationsItemsSvc(serialNumberDrone string) (*[]string, *dto.Problem)
-	LoadMedicationItemsADroneSvc(serialNumberDrone string, medicationItemIDs []interface{}) *dto.Problem
+	AddMedicationSvc(medication *dto.Medication) *dto.Problem
+	UpdateMedicationSvc(medication *dto.Medication) *dto.Problem
+	DeleteMedicationSvc(code string) *dto.Problem
+	CheckingLoadedMedicationsItemsSvc(serialNumberDrone string) (*dto.LoadedMedications, *dto.Problem)
+	LoadMedicationItemsADroneSvc(serialNumberDrone string, medicationItems []dto.MedicationItem, appendItems bool) *dto.Problem
+	RemoveMedicationItemADroneSvc(serialNumberDrone string, code string) *dto.Problem
+	UnloadMedicationItemsADroneSvc(serialNumberDrone string) *dto.Problem
 }
 
 type svcDronesReqs struct {
+	svcConf     *utils.SvcConfig
 	reposDrones *db.RepoDrones
 }
 
+// droneStateTransitions allowed moves of the drone state machine. A delivery cycle is
+// IDLE → LOADING → LOADED → DELIVERING → DELIVERED → RETURNING → IDLE
+var droneStateTransitions = map[dto.DroneState][]dto.DroneState{
+	dto.IDLE:       {dto.LOADING},
+	dto.LOADING:    {dto.LOADED, dto.IDLE}, // a loading or loaded drone can be unloaded
+	dto.LOADED:     {dto.DELIVERING, dto.IDLE},
+	dto.DELIVERING: {dto.DELIVERED},
+	dto.DELIVERED:  {dto.RETURNING},
+	dto.RETURNING:  {dto.IDLE},
+}
+
 // endregion =============================================================================
 
 // NewSvcDronesReqs instantiate the Drones request services
-func NewSvcDronesReqs(reposDrones *db.RepoDrones) ISvcDrones {
-	return &svcDronesReqs{reposDrones}
+func NewSvcDronesReqs(svcConf *utils.SvcConfig, reposDrones *db.RepoDrones) ISvcDrones {
+	return &svcDronesReqs{svcConf, reposDrones}
 }
 
 // region ======== METHODS ======================================================
@@ -69,22 +101,85 @@ func (s *svcDronesReqs) PopulateDBSvc() *dto.Problem {
 	return nil
 }
 
-func (s *svcDronesReqs) GetUserSvc(id string, filter bool) (*dto.User, *dto.Problem) {
-	res, err := (*s.reposDrones).GetUser(id, filter)
+// GetUserSvc get a user by username, the passphrase is not returned
+func (s *svcDronesReqs) GetUserSvc(username string) (*dto.User, *dto.Problem) {
+	res, err := (*s.reposDrones).GetUser(username)
 	if err != nil {
-		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+		return nil, userProblem(username, err)
 	}
+	res.Passphrase = ""
 	return res, nil
 }
 
+// GetUsersSvc get the users sorted by username, the passphrases are not returned
 func (s *svcDronesReqs) GetUsersSvc() (*[]dto.User, *dto.Problem) {
 	res, err := (*s.reposDrones).GetUsers()
 	if err != nil {
 		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
+	for i := range *res {
+		(*res)[i].Passphrase = ""
+	}
 	return res, nil
 }
 
+// CreateUserSvc adds a new enabled user, the password is stored as an argon2id hash
+func (s *svcDronesReqs) CreateUserSvc(request *dto.RequestUser) (*dto.User, *dto.Problem) {
+	passphrase, err := lib.HashPassword(request.Password)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrGeneric, err.Error())
+	}
+	user := dto.User{Username: request.Username, Passphrase: passphrase, Name: request.Name, Role: request.Role}
+	if err = (*s.reposDrones).CreateUser(&user); err != nil {
+		return nil, userProblem(request.Username, err)
+	}
+	user.Passphrase = ""
+	return &user, nil
+}
+
+// UpdateUserSvc updates the name, the password, the role or the disabled flag of a user, the omitted fields are kept
+func (s *svcDronesReqs) UpdateUserSvc(username string, request *dto.RequestUserUpdate) (*dto.User, *dto.Problem) {
+	var passphrase string
+	if request.Password != nil {
+		var err error
+		if passphrase, err = lib.HashPassword(*request.Password); err != nil {
+			return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrGeneric, err.Error())
+		}
+	}
+	res, err := (*s.reposDrones).UpdateUser(username, func(user *dto.User) error {
+		if request.Name != nil {
+			user.Name = *request.Name
+		}
+		if request.Password != nil {
+			user.Passphrase = passphrase
+		}
+		if request.Role != nil {
+			user.Role = *request.Role
+		}
+		if request.Disabled != nil {
+			user.Disabled = *request.Disabled
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, userProblem(username, err)
+	}
+	res.Passphrase = ""
+	return res, nil
+}
+
+// DisableUserSvc disables a user, a disabled user can not authenticate but its record is kept
+func (s *svcDronesReqs) DisableUserSvc(username string) *dto.Problem {
+	_, err := (*s.reposDrones).UpdateUser(username, func(user *dto.User) error {
+		user.Disabled = true
+		return nil
+	})
+	if err != nil {
+		return userProblem(username, err)
+	}
+	return nil
+}
+
 // GetADroneSvc get a specific drone
 func (s *svcDronesReqs) GetADroneSvc(serialNumber string) (*dto.Drone, *dto.Problem) {
 	res, err := (*s.reposDrones).GetDrone(serialNumber)
@@ -98,26 +193,99 @@ func (s *svcDronesReqs) GetADroneSvc(serialNumber string) (*dto.Drone, *dto.Prob
 	return res, nil
 }
 
-func (s *svcDronesReqs) GetDronesSvc(filters ...string) (*[]dto.Drone, *dto.Problem) {
-	var filter = ""
-	if len(filters) > 0 {
-		filter = filters[0]
+// GetDronesSvc get a page of the drones of the fleet, filtered and sorted. The decommissioned drones
+// are included only if it is requested
+func (s *svcDronesReqs) GetDronesSvc(filter *dto.DroneFilter) (*dto.DronesPage, *dto.Problem) {
+	res, err := (*s.reposDrones).GetDrones(filter)
+	if err == schema.ErrInvalidCursor {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrParamURL, err.Error())
+	} else if err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 
-	res, err := (*s.reposDrones).GetDrones(filter)
-	if err != nil {
+	return res, nil
+}
+
+// GetAvailableDronesSvc get the drones that can be loaded with a payload (a weight, medication items or both),
+// the rules are the same as loading a drone. The drones are ranked by remaining capacity and then by battery
+// capacity, both descending
+func (s *svcDronesReqs) GetAvailableDronesSvc(medicationItems []dto.MedicationItem, payloadWeight float64) (*[]dto.AvailableDrone, *dto.Problem) {
+	res, err := (*s.reposDrones).GetLoadableDrones(medicationItems, payloadWeight, canLoad(s.svcConf.LoadBatteryLevel()))
+	if err == schema.ErrMedicationItemNotFound {
+		return nil, lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, err.Error())
+	} else if err != nil {
 		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 
+	sort.SliceStable(*res, func(i, j int) bool {
+		a, b := (*res)[i], (*res)[j]
+		if a.RemainingCapacity != b.RemainingCapacity {
+			return a.RemainingCapacity > b.RemainingCapacity
+		}
+		if a.BatteryCapacity != b.BatteryCapacity {
+			return a.BatteryCapacity > b.BatteryCapacity
+		}
+		return a.SerialNumber < b.SerialNumber
+	})
 	return res, nil
 }
 
+// RegisterDroneSvc registers a new drone in IDLE state, the weight limit is calculated from the drone's model.
+// The fleet can not exceed the configured maximum number of drones
 func (s *svcDronesReqs) RegisterDroneSvc(drone *dto.Drone) *dto.Problem {
-	err := (*s.reposDrones).RegisterDrone(drone)
+	if drone.State != dto.IDLE {
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneStateTransitionKey, "a new drone must be registered in IDLE state")
+	}
+	drone.WeightLimit = lib.CalculateDroneWeightLimit(drone.Model)
+	drone.Decommissioned = ""
+
+	err := (*s.reposDrones).RegisterDrone(drone, s.svcConf.FleetMaxDrones)
+	if err == schema.ErrDroneFleetFull {
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneFleetFullKey, fmt.Sprintf("%s (%d)", err.Error(), s.svcConf.FleetMaxDrones))
+	}
+	return loadProblem(drone.SerialNumber, err)
+}
+
+// ReplaceDroneSvc replaces a registered drone, a state change must be allowed by the drone state machine
+func (s *svcDronesReqs) ReplaceDroneSvc(drone *dto.Drone) (*dto.Drone, *dto.Problem) {
+	res, err := (*s.reposDrones).UpdateDrone(drone.SerialNumber, func(current *dto.Drone, loaded []dto.MedicationItem) (*dto.Drone, error) {
+		request := &dto.RequestDrone{SerialNumber: drone.SerialNumber, Model: drone.Model, BatteryCapacity: drone.BatteryCapacity, State: drone.State}
+		return replaceDrone(current, request, loaded, s.svcConf.LoadBatteryLevel())
+	})
 	if err != nil {
-		return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+		return nil, loadProblem(drone.SerialNumber, err)
 	}
-	return nil
+	return res, nil
+}
+
+// PatchDroneSvc applies a JSON Merge Patch (RFC 7396) to a registered drone, the patched drone is checked with
+// validate and a state change must be allowed by the drone state machine
+func (s *svcDronesReqs) PatchDroneSvc(serialNumber string, patch map[string]interface{}, validate func(drone *dto.RequestDrone) error) (*dto.Drone, *dto.Problem) {
+	res, err := (*s.reposDrones).UpdateDrone(serialNumber, func(current *dto.Drone, loaded []dto.MedicationItem) (*dto.Drone, error) {
+		// the patch is merged over the current values, the read-only fields are not part of the request drone
+		patched := dto.RequestDrone{SerialNumber: current.SerialNumber, Model: current.Model, BatteryCapacity: current.BatteryCapacity, State: current.State}
+		if _, err := lib.UpdateJSON(patch, &patched); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidDrone, err)
+		}
+		if patched.SerialNumber != serialNumber {
+			return nil, fmt.Errorf("%w: the serial number can not be changed", errInvalidDrone)
+		}
+		if err := validate(&patched); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidDrone, err)
+		}
+		return replaceDrone(current, &patched, loaded, s.svcConf.LoadBatteryLevel())
+	})
+	if err != nil {
+		return nil, loadProblem(serialNumber, err)
+	}
+	return res, nil
+}
+
+// DecommissionDroneSvc removes an IDLE drone without medication items from the fleet, a tombstone
+// with the decommission date is kept
+func (s *svcDronesReqs) DecommissionDroneSvc(serialNumber string) *dto.Problem {
+	err := (*s.reposDrones).DecommissionDrone(serialNumber, time.Now().UTC().Format(time.RFC3339), canDecommission)
+	return loadProblem(serialNumber, err)
 }
 
 func (s *svcDronesReqs) ExistDroneSvc(serialNumber string) (bool, *dto.Problem) {
@@ -131,6 +299,72 @@ func (s *svcDronesReqs) ExistDroneSvc(serialNumber string) (bool, *dto.Problem)
 	return true, nil
 }
 
+// TransitionDroneSvc moves a drone to a new state if the drone state machine allows it and canEnter accepts it
+func (s *svcDronesReqs) TransitionDroneSvc(serialNumber string, state dto.DroneState) (*dto.Drone, *dto.Problem) {
+	drone, err := (*s.reposDrones).UpdateDroneState(serialNumber, state, canMove(s.svcConf.LoadBatteryLevel()))
+	switch {
+	case err == buntdb.ErrNotFound:
+		return nil, lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, fmt.Sprintf("the drone with serial number %s does not exist", serialNumber))
+	case err == schema.ErrDroneDecommissioned:
+		return nil, lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneDecommissionedKey, err.Error())
+	case err != nil:
+		return nil, loadProblem(serialNumber, err)
+	}
+	return drone, nil
+}
+
+// GetBatteryLevelSvc get the battery level of a drone
+func (s *svcDronesReqs) GetBatteryLevelSvc(serialNumber string) (*dto.DroneBatteryLevel, *dto.Problem) {
+	drone, problem := s.GetADroneSvc(serialNumber)
+	if problem != nil {
+		return nil, problem
+	}
+	return &dto.DroneBatteryLevel{SerialNumber: drone.SerialNumber, BatteryCapacity: drone.BatteryCapacity}, nil
+}
+
+// UpdateBatteryLevelSvc updates the battery level of a drone, a decommissioned drone can not be updated
+func (s *svcDronesReqs) UpdateBatteryLevelSvc(serialNumber string, batteryCapacity float64) (*dto.DroneBatteryLevel, *dto.Problem) {
+	drone, err := (*s.reposDrones).UpdateDrone(serialNumber, func(current *dto.Drone, _ []dto.MedicationItem) (*dto.Drone, error) {
+		current.BatteryCapacity = batteryCapacity
+		return current, nil
+	})
+	if err != nil {
+		return nil, loadProblem(serialNumber, err)
+	}
+	return &dto.DroneBatteryLevel{SerialNumber: drone.SerialNumber, BatteryCapacity: drone.BatteryCapacity}, nil
+}
+
+// ApplyTelemetrySvc applies the battery level and state reported for many drones in one transaction.
+// Each entry gets its own result, the invalid entries and the illegal state transitions are not applied
+func (s *svcDronesReqs) ApplyTelemetrySvc(telemetry []dto.RequestTelemetry, validate func(telemetry *dto.RequestTelemetry) error) (*[]dto.TelemetryResult, *dto.Problem) {
+	results := make([]dto.TelemetryResult, len(telemetry))
+	valid := make([]dto.RequestTelemetry, 0, len(telemetry))
+	positions := make([]int, 0, len(telemetry))
+	for i := range telemetry {
+		results[i] = dto.TelemetryResult{SerialNumber: telemetry[i].SerialNumber, Status: iris.StatusOK}
+		if err := validate(&telemetry[i]); err != nil {
+			results[i].Problem = loadProblem(telemetry[i].SerialNumber, fmt.Errorf("%w: %s", errInvalidDrone, err))
+			results[i].Status = results[i].Problem.Status
+			continue
+		}
+		valid = append(valid, telemetry[i])
+		positions = append(positions, i)
+	}
+
+	errs, err := (*s.reposDrones).ApplyTelemetry(valid, applyTelemetry(s.svcConf.LoadBatteryLevel()))
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	for i, err := range errs {
+		if err != nil {
+			result := &results[positions[i]]
+			result.Problem = loadProblem(result.SerialNumber, err)
+			result.Status = result.Problem.Status
+		}
+	}
+	return &results, nil
+}
+
 func (s *svcDronesReqs) GetMedicationsSvc() (*[]dto.Medication, *dto.Problem) {
 	res, err := (*s.reposDrones).GetMedications()
 	if err != nil {
@@ -139,7 +373,45 @@ func (s *svcDronesReqs) GetMedicationsSvc() (*[]dto.Medication, *dto.Problem) {
 	return res, nil
 }
 
-func (s *svcDronesReqs) CheckingLoadedMedicationsItemsSvc(serialNumberDrone string) (*[]string, *dto.Problem) {
+// AddMedicationSvc adds a new medication to the catalogue
+func (s *svcDronesReqs) AddMedicationSvc(medication *dto.Medication) *dto.Problem {
+	err := (*s.reposDrones).AddMedication(medication)
+	switch {
+	case err == schema.ErrMedicationExists:
+		return lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, fmt.Sprintf("the medication with code %s already exists", medication.Code))
+	case err != nil:
+		return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	return nil
+}
+
+// UpdateMedicationSvc replaces an existing medication of the catalogue
+func (s *svcDronesReqs) UpdateMedicationSvc(medication *dto.Medication) *dto.Problem {
+	err := (*s.reposDrones).UpdateMedication(medication)
+	switch {
+	case err == buntdb.ErrNotFound:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, fmt.Sprintf("the medication with code %s does not exist", medication.Code))
+	case err != nil:
+		return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	return nil
+}
+
+// DeleteMedicationSvc removes a medication from the catalogue, a medication loaded on a drone can not be removed
+func (s *svcDronesReqs) DeleteMedicationSvc(code string) *dto.Problem {
+	err := (*s.reposDrones).DeleteMedication(code)
+	switch {
+	case err == buntdb.ErrNotFound:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, fmt.Sprintf("the medication with code %s does not exist", code))
+	case err == schema.ErrMedicationLoaded:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrMedicationLoadedKey, err.Error())
+	case err != nil:
+		return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	return nil
+}
+
+func (s *svcDronesReqs) CheckingLoadedMedicationsItemsSvc(serialNumberDrone string) (*dto.LoadedMedications, *dto.Problem) {
 	// check that the drone exists in the database
 	err := (*s.reposDrones).ExistDrone(serialNumberDrone)
 	// Getting non-existent values will cause an ErrNotFound error.
@@ -149,38 +421,212 @@ func (s *svcDronesReqs) CheckingLoadedMedicationsItemsSvc(serialNumberDrone stri
 		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 
-	// if the drone exists, then we check if it has medication items associated with it
+	// if the drone exists, then we check if it has medication items associated with it,
+	// a drone that is not loading medication items returns an empty list
 	res, err := (*s.reposDrones).CheckingLoadedMedicationsItems(serialNumberDrone)
-
-	// Getting non-existent values will cause an ErrNotFound error.
-	// if it throws the ErrNotFound error, it is that the drone is not loading medication items
-	if err == buntdb.ErrNotFound {
-		return &[]string{}, nil
-	} else if err != nil {
+	if err != nil {
 		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 	return res, nil
 }
 
-func (s *svcDronesReqs) LoadMedicationItemsADroneSvc(serialNumberDrone string, medicationItemIDs []interface{}) *dto.Problem {
-	// get drone if exist
-	drone, errP := s.GetADroneSvc(serialNumberDrone)
-	if errP != nil {
-		return errP
+// LoadMedicationItemsADroneSvc loads an IDLE drone with medication items, or adds them to an already
+// loaded drone if appendItems is true. The checks and the load run in the same repository transaction,
+// so concurrent requests can not load the same drone twice
+func (s *svcDronesReqs) LoadMedicationItemsADroneSvc(serialNumberDrone string, medicationItems []dto.MedicationItem, appendItems bool) *dto.Problem {
+	check := canLoad(s.svcConf.LoadBatteryLevel())
+	if appendItems {
+		check = canAppend(s.svcConf.LoadBatteryLevel())
 	}
+	err := (*s.reposDrones).LoadMedicationItemsADrone(serialNumberDrone, medicationItems, appendItems, check)
+	return loadProblem(serialNumberDrone, err)
+}
 
-	// prevent the drone from being in LOADING state if the battery level is **below 25%**
-	if drone.BatteryCapacity < 25.0 {
-		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneVeryLowBatteryKey, schema.ErrDroneVeryLowBattery.Error())
-	} else if drone.State != dto.IDLE {
-		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneBusyKey, schema.ErrDroneBusy.Error())
+// RemoveMedicationItemADroneSvc removes a medication item from a loaded drone
+func (s *svcDronesReqs) RemoveMedicationItemADroneSvc(serialNumberDrone string, code string) *dto.Problem {
+	err := (*s.reposDrones).RemoveMedicationItemADrone(serialNumberDrone, code, canUnload)
+	if err == schema.ErrMedicationItemNotFound {
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, fmt.Sprintf("the medication item %s is not loaded by the drone %s", code, serialNumberDrone))
 	}
+	return loadProblem(serialNumberDrone, err)
+}
 
-	err := (*s.reposDrones).LoadMedicationItemsADrone(drone, medicationItemIDs)
-	if err == buntdb.ErrNotFound {
+// UnloadMedicationItemsADroneSvc removes every medication item from a loaded drone
+func (s *svcDronesReqs) UnloadMedicationItemsADroneSvc(serialNumberDrone string) *dto.Problem {
+	err := (*s.reposDrones).UnloadMedicationItemsADrone(serialNumberDrone, canUnload)
+	return loadProblem(serialNumberDrone, err)
+}
+
+// endregion =============================================================================
+
+// region ======== DRONE STATE MACHINE ===================================================
+
+// canLoad returns the check that a drone can move to LOADING state with the given minimum battery level
+func canLoad(minBattery float64) func(drone *dto.Drone) error {
+	return func(drone *dto.Drone) error {
+		// prevent the drone from being in LOADING state if the battery level is below the minimum
+		if drone.BatteryCapacity < minBattery {
+			return fmt.Errorf("%w, it is below %g%%", schema.ErrDroneVeryLowBattery, minBattery)
+		} else if !CanTransition(drone.State, dto.LOADING) {
+			return schema.ErrDroneBusy
+		}
+		return nil
+	}
+}
+
+// canAppend returns the check that medication items can be added to a drone, an IDLE drone is loaded as usual
+func canAppend(minBattery float64) func(drone *dto.Drone) error {
+	load := canLoad(minBattery)
+	return func(drone *dto.Drone) error {
+		if drone.State == dto.LOADING || drone.State == dto.LOADED {
+			return nil
+		}
+		return load(drone)
+	}
+}
+
+// canUnload checks that a drone carries medication items that can be unloaded
+func canUnload(drone *dto.Drone) error {
+	if drone.State != dto.LOADING && drone.State != dto.LOADED {
+		return schema.ErrDroneNotLoaded
+	}
+	return nil
+}
+
+// errInvalidDrone when a drone update does not pass the validations
+var errInvalidDrone = errors.New("invalid drone")
+
+// replaceDrone returns the current drone with the requested values, the weight limit is always
+// calculated from the drone's model and a state change must be allowed by the drone state machine.
+// loaded are the medication items carried by the drone, checked by canEnter with minBattery
+func replaceDrone(current *dto.Drone, request *dto.RequestDrone, loaded []dto.MedicationItem, minBattery float64) (*dto.Drone, error) {
+	if current.State != request.State && !CanTransition(current.State, request.State) {
+		return nil, fmt.Errorf("%w: %s → %s", schema.ErrDroneStateTransition, current.State, request.State)
+	}
+	if err := canEnter(current.State, request.State, request.BatteryCapacity, loaded, minBattery); err != nil {
+		return nil, err
+	}
+	return &dto.Drone{
+		SerialNumber:    current.SerialNumber,
+		Model:           request.Model,
+		WeightLimit:     lib.CalculateDroneWeightLimit(request.Model),
+		BatteryCapacity: request.BatteryCapacity,
+		State:           request.State,
+	}, nil
+}
+
+// canDecommission checks that a drone can be removed from the fleet, only an IDLE drone can be decommissioned
+func canDecommission(drone *dto.Drone) error {
+	if drone.State != dto.IDLE {
+		return schema.ErrDroneBusy
+	}
+	return nil
+}
+
+// loadProblem maps the errors of the drone operations (register, update, load, unload, decommission) to problems
+func loadProblem(serialNumberDrone string, err error) *dto.Problem {
+	switch {
+	case err == nil:
+		return nil
+	case err == buntdb.ErrNotFound:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, fmt.Sprintf("the drone with serial number %s does not exist", serialNumberDrone))
+	case err == schema.ErrMedicationItemNotFound:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, err.Error())
+	case errors.Is(err, schema.ErrDroneVeryLowBattery):
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneVeryLowBatteryKey, err.Error())
+	case err == schema.ErrDroneBusy:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneBusyKey, err.Error())
+	case err == schema.ErrDroneNotLoaded:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneNotLoadedKey, err.Error())
+	case err == schema.ErrDroneEmpty:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneNotLoadedKey, err.Error())
+	case err == schema.ErrDroneLoaded:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneLoadedKey, err.Error())
+	case err == schema.ErrDroneDecommissioned:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneDecommissionedKey, fmt.Sprintf("the drone with serial number %s was decommissioned", serialNumberDrone))
+	case err == schema.ErrDroneExists:
+		return lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, fmt.Sprintf("the drone with serial number %s already exists", serialNumberDrone))
+	case errors.Is(err, schema.ErrDroneStateTransition):
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneStateTransitionKey, err.Error())
+	case errors.Is(err, errInvalidDrone):
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrValidationField, err.Error())
+	case errors.Is(err, schema.ErrDroneMaximumLoadWeightExceeded):
 		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneMaximumLoadWeightExceededKey, err.Error())
-	} else if err != nil {
-		return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	case err == schema.ErrNoDroneAvailable:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrDroneUnavailableKey, err.Error())
+	}
+	return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+}
+
+// userProblem maps the errors of the user operations to problems
+func userProblem(username string, err error) *dto.Problem {
+	switch {
+	case err == buntdb.ErrNotFound:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, fmt.Sprintf("the user %s does not exist", username))
+	case err == schema.ErrUserExists:
+		return lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, fmt.Sprintf("the user %s already exists", username))
+	case err == schema.ErrLastAdmin:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrLastAdminKey, err.Error())
+	}
+	return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+}
+
+// applyTelemetry returns the function setting the reported battery level and state, a new state must be allowed by
+// the drone state machine and by canEnter
+func applyTelemetry(minBattery float64) func(drone *dto.Drone, loaded []dto.MedicationItem, telemetry *dto.RequestTelemetry) error {
+	return func(drone *dto.Drone, loaded []dto.MedicationItem, telemetry *dto.RequestTelemetry) error {
+		state := *telemetry.State
+		if state != drone.State && !CanTransition(drone.State, state) {
+			return fmt.Errorf("%w: %s → %s", schema.ErrDroneStateTransition, drone.State, state)
+		}
+		if err := canEnter(drone.State, state, *telemetry.BatteryCapacity, loaded, minBattery); err != nil {
+			return err
+		}
+		drone.BatteryCapacity = *telemetry.BatteryCapacity
+		drone.State = state
+		return nil
+	}
+}
+
+// CanTransition reports whether the drone state machine allows moving a drone between two states
+func CanTransition(from, to dto.DroneState) bool {
+	for _, next := range droneStateTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// canMove returns the check of a drone moving to a new state, the drone state machine must allow the move
+// and canEnter checks the battery level and the loaded medication items
+func canMove(minBattery float64) func(drone *dto.Drone, state dto.DroneState, loaded []dto.MedicationItem) error {
+	return func(drone *dto.Drone, state dto.DroneState, loaded []dto.MedicationItem) error {
+		if !CanTransition(drone.State, state) {
+			return fmt.Errorf("%w: %s → %s", schema.ErrDroneStateTransition, drone.State, state)
+		}
+		return canEnter(drone.State, state, drone.BatteryCapacity, loaded, minBattery)
+	}
+}
+
+// canEnter checks a drone entering a new state with a battery level. As when it is loaded, a drone
+// enters LOADING or LOADED only with medication items and the minimum battery level. It is the guard
+// of every manual move: the transitions, the replaced or patched drones and the telemetry
+func canEnter(from, to dto.DroneState, batteryCapacity float64, loaded []dto.MedicationItem, minBattery float64) error {
+	if !isLoading(to) || isLoading(from) {
+		return nil
+	}
+	if batteryCapacity < minBattery {
+		return fmt.Errorf("%w, it is below %g%%", schema.ErrDroneVeryLowBattery, minBattery)
+	} else if len(loaded) == 0 {
+		return schema.ErrDroneEmpty
 	}
 	return nil
 }
+
+// isLoading reports whether a drone in the state is being loaded or is loaded
+func isLoading(state dto.DroneState) bool {
+	return state == dto.LOADING || state == dto.LOADED
+}
+
+// endregion =============================================================================
@@ -0,0 +1,455 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+	"restapi.app/lib"
+	"restapi.app/repo/db"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+func TestCanTransition(t *testing.T) {
+	cycle := []dto.DroneState{dto.IDLE, dto.LOADING, dto.LOADED, dto.DELIVERING, dto.DELIVERED, dto.RETURNING, dto.IDLE}
+	for i := 1; i < len(cycle); i++ {
+		if !CanTransition(cycle[i-1], cycle[i]) {
+			t.Errorf("the move %s → %s must be allowed", cycle[i-1], cycle[i])
+		}
+	}
+
+	// a loading or loaded drone can be unloaded
+	for _, from := range []dto.DroneState{dto.LOADING, dto.LOADED} {
+		if !CanTransition(from, dto.IDLE) {
+			t.Errorf("the move %s → %s must be allowed", from, dto.IDLE)
+		}
+	}
+
+	illegal := [][2]dto.DroneState{
+		{dto.IDLE, dto.LOADED},
+		{dto.IDLE, dto.DELIVERING},
+		{dto.LOADED, dto.RETURNING},
+		{dto.DELIVERING, dto.RETURNING},
+		{dto.RETURNING, dto.LOADING},
+		{dto.IDLE, dto.IDLE},
+	}
+	for _, move := range illegal {
+		if CanTransition(move[0], move[1]) {
+			t.Errorf("the move %s → %s must be rejected", move[0], move[1])
+		}
+	}
+}
+
+func TestApplyTelemetry(t *testing.T) {
+	apply := applyTelemetry(25)
+	items := []dto.MedicationItem{{Code: "MED_1", Quantity: 1}}
+	telemetry := func(battery float64, state dto.DroneState) *dto.RequestTelemetry {
+		return &dto.RequestTelemetry{BatteryCapacity: &battery, State: &state}
+	}
+
+	// a drone enters LOADING or LOADED only with medication items and the minimum battery level
+	tests := []struct {
+		state     dto.DroneState
+		loaded    []dto.MedicationItem
+		telemetry *dto.RequestTelemetry
+		err       error
+	}{
+		{dto.IDLE, nil, telemetry(70, dto.LOADING), schema.ErrDroneEmpty},
+		{dto.IDLE, items, telemetry(20, dto.LOADING), schema.ErrDroneVeryLowBattery},
+		{dto.IDLE, items, telemetry(70, dto.LOADING), nil},
+		{dto.IDLE, items, telemetry(70, dto.DELIVERING), schema.ErrDroneStateTransition},
+		{dto.LOADING, items, telemetry(20, dto.LOADED), nil},
+		{dto.DELIVERING, nil, telemetry(20, dto.DELIVERED), nil},
+		{dto.IDLE, nil, telemetry(10, dto.IDLE), nil},
+	}
+	for _, test := range tests {
+		drone := &dto.Drone{SerialNumber: "SN-1", State: test.state, BatteryCapacity: 100}
+		err := apply(drone, test.loaded, test.telemetry)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s → %s: expected %v, got %v", test.state, *test.telemetry.State, test.err, err)
+			continue
+		}
+		if err == nil && (drone.State != *test.telemetry.State || drone.BatteryCapacity != *test.telemetry.BatteryCapacity) {
+			t.Errorf("%s → %s: the telemetry was not applied, got %+v", test.state, *test.telemetry.State, drone)
+		} else if err != nil && (drone.State != test.state || drone.BatteryCapacity != 100) {
+			t.Errorf("%s → %s: a rejected telemetry must not change the drone, got %+v", test.state, *test.telemetry.State, drone)
+		}
+	}
+}
+
+func TestMigrateLegacyUsers(t *testing.T) {
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+	svcConf.BootstrapAdmin = "richard.sargon@meinermail.com"
+
+	// users written by older versions, without a role, under numeric or username keys
+	store, err := buntdb.Open(svcConf.StoreDBPath)
+	if err != nil {
+		t.Fatalf("error opening the store database: %v", err)
+	}
+	err = store.Update(func(tx *buntdb.Tx) error {
+		legacy := map[string]string{
+			"1":             `{"username":"richard.sargon@meinermail.com","passphrase":"x","name":"Richard"}`,
+			"2":             `{"username":"tom.carter@meinermail.com","passphrase":"x","name":"Tom"}`,
+			"user:jane.doe": `{"username":"jane.doe","passphrase":"x","name":"Jane"}`,
+			"user:john.doe": `{"username":"john.doe","passphrase":"x","name":"John","role":"dispatcher"}`,
+		}
+		for key, value := range legacy {
+			if _, _, err := tx.Set(key, value, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error writing the legacy users: %v", err)
+	}
+	_ = store.Close()
+
+	repoDrones, err := db.NewRepoDrones(svcConf)
+	if err != nil {
+		t.Fatalf("error opening the store database: %v", err)
+	}
+	defer func() { _ = repoDrones.Close() }()
+	svc := NewSvcDronesReqs(svcConf, &repoDrones)
+
+	// only the bootstrap admin becomes admin, a role already set is kept
+	roles := map[string]string{
+		"richard.sargon@meinermail.com": dto.RoleAdmin,
+		"tom.carter@meinermail.com":     dto.RoleViewer,
+		"jane.doe":                      dto.RoleViewer,
+		"john.doe":                      dto.RoleDispatcher,
+	}
+	for username, role := range roles {
+		user, problem := svc.GetUserSvc(username)
+		if problem != nil {
+			t.Errorf("the user %s must be migrated: %s", username, problem.Detail)
+		} else if user.Role != role {
+			t.Errorf("the user %s must have the %s role, got %s", username, role, user.Role)
+		}
+	}
+}
+
+func TestRegisterDroneSvcFleetCap(t *testing.T) {
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+	svcConf.FleetMaxDrones = 3
+
+	repoDrones, err := db.NewRepoDrones(svcConf)
+	if err != nil {
+		t.Fatalf("error opening the store database: %v", err)
+	}
+	defer func() { _ = repoDrones.Close() }()
+	svc := NewSvcDronesReqs(svcConf, &repoDrones)
+
+	// concurrent registrations can not exceed the cap
+	const requests = 10
+	var wg sync.WaitGroup
+	problems := make(chan *dto.Problem, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			problems <- svc.RegisterDroneSvc(&dto.Drone{SerialNumber: lib.GenerateUUIDStr(), Model: dto.Lightweight, BatteryCapacity: 100, State: dto.IDLE})
+		}()
+	}
+	wg.Wait()
+	close(problems)
+
+	registered := 0
+	for problem := range problems {
+		switch {
+		case problem == nil:
+			registered++
+		case problem.Title != schema.ErrDroneFleetFullKey:
+			t.Errorf("unexpected problem %s: %s", problem.Title, problem.Detail)
+		}
+	}
+	if registered != svcConf.FleetMaxDrones {
+		t.Fatalf("expected %d registered drones, got %d", svcConf.FleetMaxDrones, registered)
+	}
+
+	// a decommissioned drone frees its place in the fleet
+	page, problem := svc.GetDronesSvc(&dto.DroneFilter{})
+	if problem != nil {
+		t.Fatalf("unexpected problem %s", problem.Detail)
+	}
+	if problem = svc.DecommissionDroneSvc(page.Drones[0].SerialNumber); problem != nil {
+		t.Fatalf("unexpected problem %s", problem.Detail)
+	}
+	if problem = svc.RegisterDroneSvc(&dto.Drone{SerialNumber: lib.GenerateUUIDStr(), Model: dto.Lightweight, BatteryCapacity: 100, State: dto.IDLE}); problem != nil {
+		t.Errorf("the drone must be registered in the freed place: %s", problem.Detail)
+	}
+}
+
+func TestGetDronesSvc(t *testing.T) {
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+
+	repoDrones, err := db.NewRepoDrones(svcConf)
+	if err != nil {
+		t.Fatalf("error opening the store database: %v", err)
+	}
+	defer func() { _ = repoDrones.Close() }()
+	svc := NewSvcDronesReqs(svcConf, &repoDrones)
+
+	drones := []dto.Drone{
+		{SerialNumber: "drone01", Model: dto.Lightweight, BatteryCapacity: 90},
+		{SerialNumber: "drone02", Model: dto.Heavyweight, BatteryCapacity: 40},
+		{SerialNumber: "drone03", Model: dto.Middleweight, BatteryCapacity: 90},
+		{SerialNumber: "drone04", Model: dto.Heavyweight, BatteryCapacity: 15},
+		{SerialNumber: "drone05", Model: dto.Cruiserweight, BatteryCapacity: 60},
+		{SerialNumber: "drone06", Model: dto.Lightweight, BatteryCapacity: 75},
+	}
+	for i := range drones {
+		if problem := svc.RegisterDroneSvc(&drones[i]); problem != nil {
+			t.Fatalf("error registering the drone: %s", problem.Detail)
+		}
+	}
+	if problem := svc.AddMedicationSvc(&dto.Medication{Name: "aspirin", Weight: 10, Code: "ASPIRIN"}); problem != nil {
+		t.Fatalf("error adding the medication: %s", problem.Detail)
+	}
+	if problem := svc.LoadMedicationItemsADroneSvc("drone06", []dto.MedicationItem{{Code: "ASPIRIN", Quantity: 1}}, false); problem != nil {
+		t.Fatalf("error loading the drone: %s", problem.Detail)
+	}
+	if problem := svc.DecommissionDroneSvc("drone04"); problem != nil {
+		t.Fatalf("error decommissioning the drone: %s", problem.Detail)
+	}
+
+	heavyweight, loaded := dto.Heavyweight, dto.LOADED
+	minBattery, maxBattery := 50.0, 90.0
+	cases := []struct {
+		name     string
+		filter   dto.DroneFilter
+		expected []string
+	}{
+		{"battery descending", dto.DroneFilter{Sort: dto.DroneSortBattery, Desc: true}, []string{"drone03", "drone01", "drone06", "drone05", "drone02"}},
+		{"battery ascending", dto.DroneFilter{Sort: dto.DroneSortBattery}, []string{"drone02", "drone05", "drone06", "drone01", "drone03"}},
+		{"serial descending", dto.DroneFilter{Sort: dto.DroneSortSerial, Desc: true}, []string{"drone06", "drone05", "drone03", "drone02", "drone01"}},
+		{"model", dto.DroneFilter{Sort: dto.DroneSortModel}, []string{"drone01", "drone06", "drone03", "drone05", "drone02"}},
+		{"decommissioned", dto.DroneFilter{Sort: dto.DroneSortSerial, IncludeDecommissioned: true}, []string{"drone01", "drone02", "drone03", "drone04", "drone05", "drone06"}},
+		{"model filter", dto.DroneFilter{Model: &heavyweight, IncludeDecommissioned: true}, []string{"drone04", "drone02"}},
+		{"state filter", dto.DroneFilter{State: &loaded}, []string{"drone06"}},
+		{"battery range", dto.DroneFilter{Sort: dto.DroneSortSerial, MinBattery: &minBattery, MaxBattery: &maxBattery}, []string{"drone01", "drone03", "drone05", "drone06"}},
+	}
+	for _, c := range cases {
+		// walk every page, two drones per page
+		var got []string
+		filter := c.filter
+		filter.Limit = 2
+		for pages := 0; ; pages++ {
+			if pages > len(drones) {
+				t.Fatalf("%s: the pagination does not end", c.name)
+			}
+			page, problem := svc.GetDronesSvc(&filter)
+			if problem != nil {
+				t.Fatalf("%s: unexpected problem %s", c.name, problem.Detail)
+			}
+			for _, drone := range page.Drones {
+				got = append(got, drone.SerialNumber)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			filter.Cursor = page.NextCursor
+		}
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, got)
+		}
+	}
+
+	if _, problem := svc.GetDronesSvc(&dto.DroneFilter{Cursor: "not-a-cursor"}); problem == nil || problem.Status != 400 {
+		t.Errorf("an invalid cursor must be rejected")
+	}
+}
+
+func TestGetAvailableDronesSvc(t *testing.T) {
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+
+	repoDrones, err := db.NewRepoDrones(svcConf)
+	if err != nil {
+		t.Fatalf("error opening the store database: %v", err)
+	}
+	defer func() { _ = repoDrones.Close() }()
+	svc := NewSvcDronesReqs(svcConf, &repoDrones)
+
+	if problem := svc.AddMedicationSvc(&dto.Medication{Name: "aspirin", Weight: 150, Code: "ASPIRIN"}); problem != nil {
+		t.Fatalf("error adding the medication: %s", problem.Detail)
+	}
+	drones := []dto.Drone{
+		{SerialNumber: "drone01", Model: dto.Heavyweight, BatteryCapacity: 30},
+		{SerialNumber: "drone02", Model: dto.Heavyweight, BatteryCapacity: 80},
+		{SerialNumber: "drone03", Model: dto.Middleweight, BatteryCapacity: 100},
+		{SerialNumber: "drone04", Model: dto.Lightweight, BatteryCapacity: 100},
+		{SerialNumber: "drone05", Model: dto.Heavyweight, BatteryCapacity: 20},  // very low battery
+		{SerialNumber: "drone06", Model: dto.Heavyweight, BatteryCapacity: 100}, // busy
+	}
+	for i := range drones {
+		if problem := svc.RegisterDroneSvc(&drones[i]); problem != nil {
+			t.Fatalf("error registering the drone: %s", problem.Detail)
+		}
+	}
+	if problem := svc.LoadMedicationItemsADroneSvc("drone06", []dto.MedicationItem{{Code: "ASPIRIN", Quantity: 1}}, false); problem != nil {
+		t.Fatalf("error loading the drone: %s", problem.Detail)
+	}
+
+	heavyweight, middleweight := lib.CalculateDroneWeightLimit(dto.Heavyweight), lib.CalculateDroneWeightLimit(dto.Middleweight)
+	cases := []struct {
+		name     string
+		items    []dto.MedicationItem
+		weight   float64
+		expected []string
+	}{
+		{"no payload", nil, 0, []string{"drone02", "drone01", "drone03", "drone04"}},
+		{"weight", nil, middleweight, []string{"drone02", "drone01", "drone03"}},
+		{"medication items", []dto.MedicationItem{{Code: "ASPIRIN", Quantity: 2}}, 0, []string{"drone02", "drone01"}},
+		{"weight and medication items", []dto.MedicationItem{{Code: "ASPIRIN", Quantity: 1}}, heavyweight - 150, []string{"drone02", "drone01"}},
+		{"too heavy", nil, heavyweight + 1, []string{}},
+	}
+	for _, c := range cases {
+		res, problem := svc.GetAvailableDronesSvc(c.items, c.weight)
+		if problem != nil {
+			t.Fatalf("%s: unexpected problem %s", c.name, problem.Detail)
+		}
+		got := make([]string, 0, len(*res))
+		for _, drone := range *res {
+			got = append(got, drone.SerialNumber)
+		}
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, got)
+		}
+	}
+
+	if _, problem := svc.GetAvailableDronesSvc([]dto.MedicationItem{{Code: "UNKNOWN", Quantity: 1}}, 0); problem == nil || problem.Title != schema.ErrBuntdbItemNotFound {
+		t.Errorf("an unknown medication item must be rejected")
+	}
+
+	// the minimum battery level to load a drone comes from the configuration
+	svcConf.BatteryLoadLevel = 50
+	res, problem := svc.GetAvailableDronesSvc(nil, 0)
+	if problem != nil {
+		t.Fatalf("unexpected problem %s", problem.Detail)
+	}
+	if len(*res) != 3 || (*res)[0].SerialNumber != "drone02" {
+		t.Errorf("only the drones with a battery level of at least 50%% are available, got %v", *res)
+	}
+	problem = svc.LoadMedicationItemsADroneSvc("drone01", []dto.MedicationItem{{Code: "ASPIRIN", Quantity: 1}}, false)
+	if problem == nil || problem.Title != schema.ErrDroneVeryLowBatteryKey {
+		t.Errorf("a drone below the configured battery level can not be loaded, got %+v", problem)
+	}
+}
+
+func TestDispatchStrategies(t *testing.T) {
+	candidates := []dto.AvailableDrone{
+		{Drone: dto.Drone{SerialNumber: "drone03", BatteryCapacity: 60}, RemainingCapacity: 100},
+		{Drone: dto.Drone{SerialNumber: "drone01", BatteryCapacity: 90}, RemainingCapacity: 300},
+		{Drone: dto.Drone{SerialNumber: "drone02", BatteryCapacity: 60}, RemainingCapacity: 100},
+		{Drone: dto.Drone{SerialNumber: "drone04", BatteryCapacity: 90}, RemainingCapacity: 50},
+	}
+	strategies := newDispatchStrategies()
+
+	if picked := strategies[dto.DispatchBestFit](candidates); picked.SerialNumber != "drone04" {
+		t.Errorf("best fit must pick the least remaining capacity, got %s", picked.SerialNumber)
+	}
+	if picked := strategies[dto.DispatchHighestBattery](candidates); picked.SerialNumber != "drone01" {
+		t.Errorf("highest battery must pick drone01 (ties by serial number), got %s", picked.SerialNumber)
+	}
+	for _, expected := range []string{"drone01", "drone02", "drone03", "drone04", "drone01"} {
+		if picked := strategies[dto.DispatchRoundRobin](candidates); picked.SerialNumber != expected {
+			t.Errorf("round robin must pick %s, got %s", expected, picked.SerialNumber)
+		}
+	}
+	// a busy drone loses its turn
+	if picked := strategies[dto.DispatchRoundRobin](candidates[2:]); picked.SerialNumber != "drone02" {
+		t.Errorf("round robin must pick drone02, got %s", picked.SerialNumber)
+	}
+
+	// the configured strategy is checked on startup
+	for strategy, valid := range map[string]bool{dto.DispatchRoundRobin: true, "": true, "bestfit": false} {
+		if err := CheckDispatchStrategy(strategy); (err == nil) != valid {
+			t.Errorf("the configured strategy '%s' must be valid: %v, got %v", strategy, valid, err)
+		}
+	}
+}
+
+func TestPackLoads(t *testing.T) {
+	candidates := []dto.AvailableDrone{
+		{Drone: dto.Drone{SerialNumber: "drone01", WeightLimit: 125, BatteryCapacity: 100}},
+		{Drone: dto.Drone{SerialNumber: "drone02", WeightLimit: 500, BatteryCapacity: 50}},
+		{Drone: dto.Drone{SerialNumber: "drone03", WeightLimit: 500, BatteryCapacity: 90}},
+	}
+	weights := map[string]float64{"HEAVY": 200, "LIGHT": 50}
+
+	// 3 x 200gr + 4 x 50gr = 800gr, more than a single drone can carry
+	loads, err := packLoads(candidates, weights, []dto.MedicationItem{{Code: "LIGHT", Quantity: 4}, {Code: "HEAVY", Quantity: 3}})
+	if err != nil {
+		t.Fatalf("the payload must be planned: %v", err)
+	}
+	if len(loads) != 2 || loads[0].SerialNumber != "drone03" || loads[1].SerialNumber != "drone02" {
+		t.Fatalf("the payload must be split across the largest drones, got %+v", loads)
+	}
+	var total float64
+	for _, load := range loads {
+		if load.Weight > load.WeightLimit {
+			t.Errorf("the load of %s exceeds its weight limit: %.2f", load.SerialNumber, load.Weight)
+		}
+		total += load.Weight
+	}
+	if total != 800 {
+		t.Errorf("the plan must carry the whole payload, got %.2f", total)
+	}
+
+	// a unit heavier than every drone can not be split
+	if _, err = packLoads(candidates, map[string]float64{"HUGE": 700}, []dto.MedicationItem{{Code: "HUGE", Quantity: 1}}); !errors.Is(err, schema.ErrDroneMaximumLoadWeightExceeded) {
+		t.Errorf("a medication heavier than every drone must be rejected, got %v", err)
+	}
+	// the fleet can not carry the payload
+	if _, err = packLoads(candidates, weights, []dto.MedicationItem{{Code: "HEAVY", Quantity: 6}}); !errors.Is(err, schema.ErrDroneMaximumLoadWeightExceeded) {
+		t.Errorf("a payload heavier than the available drones must be rejected, got %v", err)
+	}
+}
+
+func TestLastAdmin(t *testing.T) {
+	svcConf := &utils.SvcConfig{}
+	svcConf.StoreDBPath = filepath.Join(t.TempDir(), "data.db")
+
+	repoDrones, err := db.NewRepoDrones(svcConf)
+	if err != nil {
+		t.Fatalf("error opening the store database: %v", err)
+	}
+	defer func() { _ = repoDrones.Close() }()
+	svc := NewSvcDronesReqs(svcConf, &repoDrones)
+
+	expect := func(step int, problem *dto.Problem, status uint) {
+		if status == 0 && problem != nil {
+			t.Errorf("step #%d: unexpected problem %s", step, problem.Detail)
+		} else if status != 0 && (problem == nil || problem.Status != status || problem.Title != schema.ErrLastAdminKey) {
+			t.Errorf("step #%d: expected the %s problem, got %+v", step, schema.ErrLastAdminKey, problem)
+		}
+	}
+
+	// the seeded admin is the only one, it can not be demoted or disabled
+	role := dto.RoleDispatcher
+	disabled := true
+	_, problem := svc.UpdateUserSvc("richard.sargon@meinermail.com", &dto.RequestUserUpdate{Role: &role})
+	expect(1, problem, 412)
+	_, problem = svc.UpdateUserSvc("richard.sargon@meinermail.com", &dto.RequestUserUpdate{Disabled: &disabled})
+	expect(2, problem, 412)
+	expect(3, svc.DisableUserSvc("richard.sargon@meinermail.com"), 412)
+
+	// with another admin, the first one can be disabled, but not the last one
+	_, problem = svc.CreateUserSvc(&dto.RequestUser{Username: "jane.doe", Password: "password3", Name: "Jane Doe", Role: dto.RoleAdmin})
+	expect(4, problem, 0)
+	expect(5, svc.DisableUserSvc("richard.sargon@meinermail.com"), 0)
+	_, problem = svc.UpdateUserSvc("jane.doe", &dto.RequestUserUpdate{Role: &role})
+	expect(6, problem, 412)
+
+	// the other users are not checked
+	expect(7, svc.DisableUserSvc("tom.carter@meinermail.com"), 0)
+}
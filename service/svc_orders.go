@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/kataras/iris/v12"
+	"github.com/tidwall/buntdb"
+	"restapi.app/lib"
+	"restapi.app/repo/db"
+	"restapi.app/schema"
+	"restapi.app/schema/dto"
+	"restapi.app/service/utils"
+)
+
+// region ======== SETUP =================================================================
+
+// ISvcOrders Orders request service interface
+type ISvcOrders interface {
+	GetOrdersSvc(filter *dto.OrderFilter) (*[]dto.Order, *dto.Problem)
+	GetOrderSvc(id string) (*dto.Order, *dto.Problem)
+	CreateOrderSvc(request *dto.RequestOrder) (*dto.Order, *dto.Problem)
+	CancelOrderSvc(id string) *dto.Problem
+	DispatchSvc(request *dto.RequestDispatch) (*dto.Dispatch, *dto.Problem)
+	PlanDispatchSvc(request *dto.RequestDispatchPlan, commit bool) (*dto.DispatchPlan, *dto.Problem)
+}
+
+type svcOrdersReqs struct {
+	svcConf     *utils.SvcConfig
+	reposDrones *db.RepoDrones
+	strategies  map[string]DispatchStrategy
+}
+
+// endregion =============================================================================
+
+// NewSvcOrdersReqs instantiate the Orders services
+func NewSvcOrdersReqs(svcConf *utils.SvcConfig, reposDrones *db.RepoDrones) ISvcOrders {
+	return &svcOrdersReqs{svcConf, reposDrones, newDispatchStrategies()}
+}
+
+// region ======== METHODS ===============================================================
+
+// GetOrdersSvc get the orders, the newest first
+func (s *svcOrdersReqs) GetOrdersSvc(filter *dto.OrderFilter) (*[]dto.Order, *dto.Problem) {
+	res, err := (*s.reposDrones).GetOrders(filter)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	return res, nil
+}
+
+func (s *svcOrdersReqs) GetOrderSvc(id string) (*dto.Order, *dto.Problem) {
+	res, err := (*s.reposDrones).GetOrder(id)
+	if err != nil {
+		return nil, orderProblem(id, err)
+	}
+	return res, nil
+}
+
+// CreateOrderSvc creates an order and loads the drone with its medication items, the drone must be
+// able to start loading
+func (s *svcOrdersReqs) CreateOrderSvc(request *dto.RequestOrder) (*dto.Order, *dto.Problem) {
+	res, err := (*s.reposDrones).CreateOrder(request.SerialNumber, request.Destination, request.Items, canLoad(s.svcConf.LoadBatteryLevel()))
+	if err != nil {
+		return nil, loadProblem(request.SerialNumber, err)
+	}
+	return res, nil
+}
+
+// CancelOrderSvc cancels an order that is not delivering yet, its drone is unloaded
+func (s *svcOrdersReqs) CancelOrderSvc(id string) *dto.Problem {
+	err := (*s.reposDrones).CancelOrder(id, canUnload)
+	return orderProblem(id, err)
+}
+
+// DispatchSvc picks a drone for the medication items and creates the order loading it. The strategy of the
+// request, or the configured one, chooses among the IDLE drones with enough battery and weight limit. The
+// configured strategy is checked on startup, only an unknown strategy of the request is a bad request
+func (s *svcOrdersReqs) DispatchSvc(request *dto.RequestDispatch) (*dto.Dispatch, *dto.Problem) {
+	strategy := request.Strategy
+	if strategy == "" {
+		strategy = s.svcConf.DispatchStrategy
+	}
+	if strategy == "" {
+		strategy = dto.DispatchBestFit
+	}
+	pick, ok := s.strategies[strategy]
+	if !ok {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrValidationField, fmt.Sprintf("unknown dispatch strategy %s", strategy))
+	}
+
+	drone, order, err := (*s.reposDrones).DispatchOrder(request.Destination, request.Items, canLoad(s.svcConf.LoadBatteryLevel()), pick)
+	switch {
+	case err == schema.ErrMedicationItemNotFound:
+		return nil, lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, err.Error())
+	case err != nil:
+		return nil, loadProblem("", err)
+	}
+	return &dto.Dispatch{Strategy: strategy, Drone: *drone, Order: *order}, nil
+}
+
+// PlanDispatchSvc splits the medication items across the available drones when they are too heavy for a single one.
+// A committed plan loads every drone of the plan through its own order, in the same transaction
+func (s *svcOrdersReqs) PlanDispatchSvc(request *dto.RequestDispatchPlan, commit bool) (*dto.DispatchPlan, *dto.Problem) {
+	plan, err := (*s.reposDrones).PlanDispatch(request.Destination, request.Items, commit, canLoad(s.svcConf.LoadBatteryLevel()), packLoads)
+	switch {
+	case err == schema.ErrMedicationItemNotFound:
+		return nil, lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, err.Error())
+	case err != nil:
+		return nil, loadProblem("", err)
+	}
+	return plan, nil
+}
+
+// endregion =============================================================================
+
+// region ======== PRIVATE AUX ===========================================================
+
+// orderProblem maps the errors of the order operations, the errors of its drone are mapped as usual
+func orderProblem(id string, err error) *dto.Problem {
+	switch {
+	case err == nil:
+		return nil
+	case err == buntdb.ErrNotFound:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrBuntdbItemNotFound, fmt.Sprintf("the order %s does not exist", id))
+	case err == schema.ErrOrderClosed:
+		return lib.NewProblem(iris.StatusPreconditionFailed, schema.ErrOrderClosedKey, err.Error())
+	}
+	return loadProblem(id, err)
+}
+
+// endregion =============================================================================
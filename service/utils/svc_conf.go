@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/tkanos/gonfig"
 	"restapi.app/lib"
@@ -18,18 +19,52 @@ type conf struct { //nolint:maligned
 	DappPort string
 
 	// Cryptographic conf
-	JWTSignKey string
-	TkMaxAge   uint8
+	JWTSignKey      string
+	TkMaxAge        int // lifetime (in minutes) of the access tokens
+	RefreshTkMaxAge int // lifetime (in hours) of the refresh tokens, a refresh token is rotated on every use (0 = DefaultRefreshTkMaxAge)
 
 	// STORE DB
-	StoreDBPath string
+	StoreDBPath    string
+	BootstrapAdmin string // user written by an older version, without a role, that becomes admin (the other ones become viewers)
+
+	// FLEET
+	FleetMaxDrones   int    // maximum number of drones in the fleet, the decommissioned ones are not counted (0 = unlimited)
+	DispatchStrategy string // default strategy picking the drone of a dispatch: bestFit, highestBattery or roundRobin
 
 	// CRON JOB
-	CronEnabled bool
-	LogDBPath   string
-	EveryTime   int
+	CronEnabled  bool
+	LogDBPath    string
+	EveryTime    int
+	LogMaxEvents int // retention: maximum number of events kept in the event log (0 = unlimited)
+	LogMaxAge    int // retention: maximum age (in hours) of the events kept in the event log (0 = unlimited)
+
+	// SIMULATOR
+	SimEnabled        bool    // opt-in simulation of the battery levels and the delivery cycle of the drones
+	SimEveryTime      int     // time interval (in seconds) between two simulation steps
+	SimDrainRate      float64 // battery percentage drained per step while DELIVERING or RETURNING
+	SimChargeRate     float64 // battery percentage charged per step while IDLE
+	SimLoadedTime     int     // seconds a LOADED drone waits before DELIVERING (0 = it stays LOADED)
+	SimDeliveringTime int     // seconds a DELIVERING drone takes to be DELIVERED (0 = it stays DELIVERING)
+	SimDeliveredTime  int     // seconds a DELIVERED drone waits before RETURNING (0 = it stays DELIVERED)
+	SimReturningTime  int     // seconds a RETURNING drone takes to be IDLE (0 = it stays RETURNING)
+
+	// ALERTS
+	BatteryLoadLevel   float64   // minimum battery level (percentage) to load a drone (0 = DefaultBatteryLoadLevel)
+	AlertThresholds    []float64 // battery levels (percentage) that create an alert when a drone drops below them
+	AlertEveryTime     int       // time interval (in seconds) between two deliveries of the pending alerts
+	WebhookURLs        []string  // webhooks receiving the alerts as a signed JSON POST
+	WebhookSecret      string    // key signing the alerts with HMAC-SHA256
+	WebhookMaxAttempts int       // attempts to deliver an alert to a webhook before it fails
+	WebhookBackoff     int       // seconds before the first retry, the wait doubles after every failed attempt
+	WebhookTimeout     int       // seconds to wait for the response of a webhook
 }
 
+// DefaultBatteryLoadLevel the minimum battery level to load a drone when BatteryLoadLevel is not configured
+const DefaultBatteryLoadLevel = 25.0
+
+// DefaultRefreshTkMaxAge the lifetime (in hours) of the refresh tokens when RefreshTkMaxAge is not configured
+const DefaultRefreshTkMaxAge = 168
+
 // SvcConfig exported configuration service struct
 type SvcConfig struct {
 	Path string `string:"Path to the config YAML file"`
@@ -38,6 +73,22 @@ type SvcConfig struct {
 
 // endregion =============================================================================
 
+// LoadBatteryLevel the minimum battery level (percentage) to load a drone
+func (c *SvcConfig) LoadBatteryLevel() float64 {
+	if c.BatteryLoadLevel <= 0 {
+		return DefaultBatteryLoadLevel
+	}
+	return c.BatteryLoadLevel
+}
+
+// RefreshTokenMaxAge the lifetime of the refresh tokens
+func (c *SvcConfig) RefreshTokenMaxAge() time.Duration {
+	if c.RefreshTkMaxAge <= 0 {
+		return DefaultRefreshTkMaxAge * time.Hour
+	}
+	return time.Duration(c.RefreshTkMaxAge) * time.Hour
+}
+
 // NewSvcConfig create a new configuration service.
 func NewSvcConfig() *SvcConfig {
 	c := conf{}
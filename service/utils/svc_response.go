@@ -67,10 +67,11 @@ func (s SvcResponse) ResCreated(ctx *iris.Context) {
 //
 // - ctx [*iris.Context] ~ Iris Request context
 func (s SvcResponse) ResCreatedWithData(data interface{}, ctx *iris.Context) {
+	// the status code must be set before the body is written
+	(*ctx).StatusCode(iris.StatusCreated)
 	if err := (*ctx).JSON(data); err != nil { // Logging *marshal* json if error occurs (come internally from iris)
 		(*ctx).Application().Logger().Error(err.Error())
 	}
-	(*ctx).StatusCode(iris.StatusCreated)
 }
 
 // ResDelete create response 204. It's delete confirmation wit empty retrieving data.